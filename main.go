@@ -12,6 +12,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -71,8 +73,10 @@ func usage() {
 		"/managerservice",
 		"/tunnelservice CONFIG_PATH",
 		"/ui CMD_READ_HANDLE CMD_WRITE_HANDLE CMD_EVENT_HANDLE LOG_MAPPING_HANDLE",
-		"/dumplog [/tail]",
-		"/update",
+		"/dumplog [/tail] [/json] [/since TIME] [/until TIME] [/tag TAG] [/tunnel TUNNEL_NAME] [/level LEVEL] [/match TEXT] [/regex PATTERN] [/redact LEVEL]",
+		"/diagnose OUTPUT_ZIP [/redact LEVEL]",
+		"/update [/from DIR | --from DIR]",
+		"/setupdateproxypassword",
 	}
 	builder := strings.Builder{}
 	for _, flag := range flags {
@@ -148,6 +152,56 @@ func pipeFromHandleArgument(handleStr string) (*os.File, error) {
 	return os.NewFile(uintptr(handleInt), "pipe"), nil
 }
 
+// parseDumpLogOptions accepts each option either with a slash or with a
+// double dash, so "/since" and "--since" are the same.
+func parseDumpLogOptions(args []string) (*ringlogger.DumpOptions, error) {
+	options := &ringlogger.DumpOptions{}
+	now := time.Now()
+	for i := 0; i < len(args); i++ {
+		option := args[i]
+		if strings.HasPrefix(option, "--") {
+			option = "/" + option[2:]
+		}
+		switch option {
+		case "/tail":
+			options.Continuous = true
+			continue
+		case "/json":
+			options.JSON = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("Missing argument for %s", args[i])
+		}
+		i++
+		var err error
+		switch option {
+		case "/since":
+			options.Filter.Since, err = ringlogger.ParseTime(args[i], now)
+		case "/until":
+			options.Filter.Until, err = ringlogger.ParseTime(args[i], now)
+		case "/tag":
+			options.Filter.Tags = append(options.Filter.Tags, strings.Split(args[i], ",")...)
+		case "/tunnel":
+			options.Filter.Tunnel = args[i]
+		case "/level":
+			options.Filter.MaxLevel, err = ringlogger.ParseLevel(args[i])
+		case "/match":
+			options.Filter.Contains = args[i]
+		case "/regex":
+			options.Filter.Regexp, err = regexp.Compile(args[i])
+		case "/redact":
+			options.Redaction, err = ringlogger.ParseRedactionLevel(args[i])
+		default:
+			err = fmt.Errorf("Unknown option %s", args[i-1])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
 func main() {
 	if windows.SetDllDirectory("") != nil || windows.SetDefaultDllDirectories(windows.LOAD_LIBRARY_SEARCH_SYSTEM32) != nil {
 		panic("failed to restrict dll search path")
@@ -224,6 +278,7 @@ func main() {
 		if len(os.Args) != 3 {
 			usage()
 		}
+		manager.CaptureCrashes("TUN")
 		err := tunnel.Run(os.Args[2])
 		if err != nil {
 			fatal(err)
@@ -267,8 +322,9 @@ func main() {
 		ui.RunUI()
 		return
 	case "/dumplog":
-		if len(os.Args) != 2 && len(os.Args) != 3 {
-			usage()
+		options, err := parseDumpLogOptions(os.Args[2:])
+		if err != nil {
+			fatal(err)
 		}
 		outputHandle, err := windows.GetStdHandle(windows.STD_OUTPUT_HANDLE)
 		if err != nil {
@@ -283,26 +339,75 @@ func main() {
 		if err != nil {
 			fatal(err)
 		}
-		err = ringlogger.DumpTo(logPath, file, len(os.Args) == 3 && os.Args[2] == "/tail")
+		options.ArchiveDir, err = manager.LogArchiveDirectory(false)
+		if err != nil {
+			fatal(err)
+		}
+		err = ringlogger.DumpTo(logPath, file, options)
+		if err != nil {
+			fatal(err)
+		}
+		return
+	case "/diagnose":
+		if len(os.Args) != 3 && (len(os.Args) != 5 || (os.Args[3] != "/redact" && os.Args[3] != "--redact")) {
+			usage()
+		}
+		redaction := ringlogger.RedactKeys
+		if len(os.Args) == 5 {
+			var err error
+			redaction, err = ringlogger.ParseRedactionLevel(os.Args[4])
+			if err != nil {
+				fatal(err)
+			}
+		}
+		file, err := os.Create(os.Args[2])
+		if err != nil {
+			fatal(err)
+		}
+		err = elevate.DoAsSystem(func() error {
+			return manager.WriteDiagnostics(file, redaction)
+		})
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
 		if err != nil {
+			os.Remove(os.Args[2])
 			fatal(err)
 		}
 		return
 	case "/update":
-		if len(os.Args) != 2 {
+		var updateProgress chan updater.DownloadProgress
+		if len(os.Args) == 4 && (os.Args[2] == "/from" || os.Args[2] == "--from") {
+			folder, err := filepath.Abs(os.Args[3])
+			if err != nil {
+				fatal(err)
+			}
+			updateProgress = updater.DownloadVerifyAndExecuteFrom(folder, 0)
+		} else if len(os.Args) == 2 {
+			updateProgress = updater.DownloadVerifyAndExecute(0)
+		} else {
 			usage()
 		}
-		for progress := range updater.DownloadVerifyAndExecute(0) {
+		var activity string
+		for progress := range updateProgress {
 			if len(progress.Activity) > 0 {
-				if progress.BytesTotal > 0 || progress.BytesDownloaded > 0 {
-					var percent float64
-					if progress.BytesTotal > 0 {
-						percent = float64(progress.BytesDownloaded) / float64(progress.BytesTotal) * 100.0
-					}
-					log.Printf("%s: %d/%d (%.2f%%)\n", progress.Activity, progress.BytesDownloaded, progress.BytesTotal, percent)
-				} else {
-					log.Println(progress.Activity)
+				activity = progress.Activity
+			}
+			if progress.BytesTotal > 0 || progress.BytesDownloaded > 0 {
+				var percent float64
+				if progress.BytesTotal > 0 {
+					percent = float64(progress.BytesDownloaded) / float64(progress.BytesTotal) * 100.0
+				}
+				var throughput string
+				if progress.BytesPerSecond > 0 {
+					throughput = fmt.Sprintf(", %.2f KiB/s", float64(progress.BytesPerSecond)/1024)
 				}
+				if progress.Remaining > 0 {
+					throughput += fmt.Sprintf(", %v remaining", progress.Remaining)
+				}
+				log.Printf("%s: %d/%d (%.2f%%)%s\n", activity, progress.BytesDownloaded, progress.BytesTotal, percent, throughput)
+			} else if len(progress.Activity) > 0 {
+				log.Println(progress.Activity)
 			}
 			if progress.Error != nil {
 				log.Printf("Error: %v\n", progress.Error)
@@ -312,6 +417,19 @@ func main() {
 			}
 		}
 		return
+	case "/setupdateproxypassword":
+		if len(os.Args) != 2 {
+			usage()
+		}
+		password, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+		err = updater.SetProxyPassword(strings.TrimRight(string(password), "\r\n"))
+		if err != nil {
+			fatal(err)
+		}
+		return
 	}
 	usage()
 }
@@ -0,0 +1,58 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+)
+
+// crashFiles holds the names of the crash files that the manager collected
+// since it started, so that the UI and diagnostics can report them.
+var (
+	crashFiles     []string
+	crashFilesLock sync.Mutex
+)
+
+// CaptureCrashes has crashes of this process saved to crash files next to the
+// log, which the manager collects.
+func CaptureCrashes(tag string) {
+	logFile, err := LogFile(false)
+	if err != nil {
+		return
+	}
+	err = ringlogger.CaptureCrashes(filepath.Dir(logFile), tag)
+	if err != nil {
+		log.Printf("Unable to capture crashes: %v", err)
+	}
+}
+
+// collectCrashes collects the crash files of processes that crashed, which the
+// manager does when it starts and whenever a tunnel service stops. Only the
+// manager collects them, so that a tunnel service starting does not take those
+// of another process.
+func collectCrashes() {
+	logFile, err := LogFile(false)
+	if err != nil {
+		return
+	}
+	crashes := ringlogger.CollectCrashes(filepath.Dir(logFile))
+	if len(crashes) == 0 {
+		return
+	}
+	crashFilesLock.Lock()
+	crashFiles = append(crashFiles, crashes...)
+	crashFilesLock.Unlock()
+}
+
+func currentCrashFiles() []string {
+	crashFilesLock.Lock()
+	defer crashFilesLock.Unlock()
+	return append([]string{}, crashFiles...)
+}
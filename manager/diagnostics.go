@@ -0,0 +1,315 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
+	"github.com/amnezia-vpn/amneziawg-windows/conf"
+	"github.com/amnezia-vpn/amneziawg-windows/services"
+)
+
+type diagnosticsFile struct {
+	Name        string
+	Description string
+	Error       string `json:",omitempty"`
+}
+
+type diagnosticsManifest struct {
+	Generated time.Time
+	UserAgent string
+	Files     []diagnosticsFile
+}
+
+type diagnosticsWriter struct {
+	zip      *zip.Writer
+	manifest diagnosticsManifest
+	redactor *ringlogger.Redactor // Shared by all files, so that they use the same pseudonyms.
+}
+
+func (dw *diagnosticsWriter) add(name, description string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	err := write(&buf)
+	file := diagnosticsFile{Name: name, Description: description, Error: errToString(err)}
+	dw.manifest.Files = append(dw.manifest.Files, file)
+	if err != nil && buf.Len() == 0 {
+		return nil
+	}
+	w, err := dw.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: dw.manifest.Generated})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, dw.redactor.Redact(buf.String()))
+	return err
+}
+
+// WriteDiagnostics writes a zip archive to out containing everything usually
+// asked of users reporting a problem. Private keys are always left out of the
+// configurations, and every file is redacted according to redaction.
+func WriteDiagnostics(out io.Writer, redaction ringlogger.RedactionLevel) error {
+	dw := &diagnosticsWriter{
+		zip: zip.NewWriter(out),
+		manifest: diagnosticsManifest{
+			Generated: time.Now(),
+			UserAgent: version.UserAgent(),
+		},
+		redactor: ringlogger.NewRedactor(redaction),
+	}
+	err := dw.add("system.txt", "Operating system and application version", writeSystemDiagnostics)
+	if err != nil {
+		return err
+	}
+	err = dw.add("manager.txt", "Manager service state and update state", writeManagerDiagnostics)
+	if err != nil {
+		return err
+	}
+	err = dw.add("adapters.txt", "Network adapter summary", writeAdapterDiagnostics)
+	if err != nil {
+		return err
+	}
+	err = dw.add("routes.txt", "Routing table summary", writeRouteDiagnostics)
+	if err != nil {
+		return err
+	}
+	names, err := conf.ListConfigNames()
+	if err != nil {
+		dw.manifest.Files = append(dw.manifest.Files, diagnosticsFile{Name: "tunnels/", Description: "Tunnel configurations", Error: err.Error()})
+	}
+	service := &ManagerService{}
+	for _, name := range names {
+		dir := "tunnels/" + name + "/"
+		err = dw.add(dir+"service.txt", fmt.Sprintf("Tunnel service state of ‘%s’", name), func(w io.Writer) error {
+			return writeTunnelServiceDiagnostics(w, name)
+		})
+		if err != nil {
+			return err
+		}
+		err = dw.add(dir+"stored.conf", fmt.Sprintf("Redacted stored configuration of ‘%s’", name), func(w io.Writer) error {
+			config, err := service.StoredConfig(name)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, config.ToWgQuick())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if state, _ := service.State(name); state != TunnelStarted {
+			continue
+		}
+		err = dw.add(dir+"runtime.conf", fmt.Sprintf("Redacted runtime configuration of ‘%s’", name), func(w io.Writer) error {
+			config, err := service.RuntimeConfig(name)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, config.ToWgQuick())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = dw.add("log.txt", "Ring log of the manager", func(w io.Writer) error {
+		if ringlogger.Global != nil {
+			_, err := ringlogger.Global.WriteTo(w)
+			return err
+		}
+		logPath, err := LogFile(false)
+		if err != nil {
+			return err
+		}
+		return ringlogger.DumpTo(logPath, w, &ringlogger.DumpOptions{})
+	})
+	if err != nil {
+		return err
+	}
+	err = dw.add("tunnel-log.txt", "Ring log of the tunnel services", func(w io.Writer) error {
+		logPath, err := TunnelLogFile(false)
+		if err != nil {
+			return err
+		}
+		return ringlogger.DumpTo(logPath, w, &ringlogger.DumpOptions{})
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range currentCrashFiles() {
+		err = dw.add("crashes/"+name, "Goroutine dump of a crash of the manager or a tunnel service", func(w io.Writer) error {
+			logPath, err := LogFile(false)
+			if err != nil {
+				return err
+			}
+			file, err := os.Open(filepath.Join(filepath.Dir(logPath), name))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(w, file)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	manifest, err := json.MarshalIndent(&dw.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	w, err := dw.zip.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: dw.manifest.Generated})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(manifest)
+	if err != nil {
+		return err
+	}
+	return dw.zip.Close()
+}
+
+func writeSystemDiagnostics(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Version: %s\nArchitecture: %s\nOperating system: %s\nOfficial build: %t\nEV signed: %t\n",
+		version.Number, version.Arch(), version.OsName(), version.IsRunningOfficialVersion(), version.IsRunningEVSigned())
+	return err
+}
+
+func writeManagerDiagnostics(w io.Writer) error {
+	m, err := serviceManager()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Update state: %s\n", currentUpdateState())
+	service, err := m.OpenService("AmneziaWGManager")
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	return writeServiceStatus(w, service.Query)
+}
+
+func writeTunnelServiceDiagnostics(w io.Writer, tunnelName string) error {
+	serviceName, err := services.ServiceNameOfTunnel(tunnelName)
+	if err != nil {
+		return err
+	}
+	m, err := serviceManager()
+	if err != nil {
+		return err
+	}
+	service, err := m.OpenService(serviceName)
+	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
+		_, err = fmt.Fprintf(w, "Service: %s\nState: not installed\n", serviceName)
+		return err
+	} else if err != nil {
+		return err
+	}
+	defer service.Close()
+	fmt.Fprintf(w, "Service: %s\n", serviceName)
+	return writeServiceStatus(w, service.Query)
+}
+
+func writeServiceStatus(w io.Writer, query func() (svc.Status, error)) error {
+	status, err := query()
+	if err != nil {
+		return err
+	}
+	var state string
+	switch status.State {
+	case svc.Stopped:
+		state = "stopped"
+	case svc.StartPending:
+		state = "start pending"
+	case svc.StopPending:
+		state = "stop pending"
+	case svc.Running:
+		state = "running"
+	default:
+		state = fmt.Sprintf("%d", status.State)
+	}
+	exitCode := fmt.Sprintf("%d (%v)", status.Win32ExitCode, windows.Errno(status.Win32ExitCode))
+	if status.Win32ExitCode == uint32(windows.ERROR_SERVICE_SPECIFIC_ERROR) {
+		exitCode = fmt.Sprintf("service specific %d (%v)", status.ServiceSpecificExitCode, services.Error(status.ServiceSpecificExitCode))
+	}
+	_, err = fmt.Fprintf(w, "State: %s\nProcess ID: %d\nExit code: %s\n", state, status.ProcessId, exitCode)
+	return err
+}
+
+func writeAdapterDiagnostics(w io.Writer) error {
+	var buf []byte
+	size := uint32(15000)
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_GATEWAYS|windows.GAA_FLAG_INCLUDE_ALL_INTERFACES, 0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW || size <= uint32(len(buf)) {
+			return err
+		}
+	}
+	for adapter := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); adapter != nil; adapter = adapter.Next {
+		operStatus := "down"
+		if adapter.OperStatus == windows.IfOperStatusUp {
+			operStatus = "up"
+		}
+		fmt.Fprintf(w, "%s (%s)\n", windows.UTF16PtrToString(adapter.FriendlyName), windows.UTF16PtrToString(adapter.Description))
+		fmt.Fprintf(w, "  index: %d, luid: %#x, type: %d, status: %s, mtu: %d, metrics: %d/%d\n",
+			adapter.IfIndex, adapter.Luid, adapter.IfType, operStatus, adapter.Mtu, adapter.Ipv4Metric, adapter.Ipv6Metric)
+		for address := adapter.FirstUnicastAddress; address != nil; address = address.Next {
+			fmt.Fprintf(w, "  address: %v/%d\n", address.Address.IP(), address.OnLinkPrefixLength)
+		}
+		for address := adapter.FirstGatewayAddress; address != nil; address = address.Next {
+			fmt.Fprintf(w, "  gateway: %v\n", address.Address.IP())
+		}
+		for address := adapter.FirstDnsServerAddress; address != nil; address = address.Next {
+			fmt.Fprintf(w, "  dns: %v\n", address.Address.IP())
+		}
+	}
+	return nil
+}
+
+func sockaddrInetToAddr(sa *windows.RawSockaddrInet6) netip.Addr {
+	switch sa.Family {
+	case windows.AF_INET:
+		sa4 := (*windows.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return netip.AddrFrom4(sa4.Addr)
+	case windows.AF_INET6:
+		return netip.AddrFrom16(sa.Addr)
+	}
+	return netip.Addr{}
+}
+
+func writeRouteDiagnostics(w io.Writer) error {
+	var table *mibIPforwardTable2
+	err := getIPForwardTable2(windows.AF_UNSPEC, &table)
+	if err != nil {
+		return err
+	}
+	defer freeMibTable(uintptr(unsafe.Pointer(table)))
+	for _, row := range unsafe.Slice(&table.table[0], table.numEntries) {
+		destination := sockaddrInetToAddr(&row.destinationPrefix.prefix)
+		if destination.IsMulticast() || (destination.Is6() && destination.IsLinkLocalUnicast()) {
+			continue
+		}
+		fmt.Fprintf(w, "%v/%d via %v if %d metric %d protocol %d\n",
+			destination, row.destinationPrefix.prefixLength, sockaddrInetToAddr(&row.nextHop), row.interfaceIndex, row.metric, row.protocol)
+	}
+	return nil
+}
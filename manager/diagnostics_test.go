@@ -0,0 +1,61 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+)
+
+func TestDiagnosticsRedaction(t *testing.T) {
+	const (
+		key     = "ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE0="
+		address = "192.0.2.10"
+	)
+	var out bytes.Buffer
+	dw := &diagnosticsWriter{zip: zip.NewWriter(&out), redactor: ringlogger.NewRedactor(ringlogger.RedactAddresses)}
+	for _, name := range []string{"routes.txt", "stored.conf", "log.txt"} {
+		err := dw.add(name, name, func(w io.Writer) error {
+			_, err := io.WriteString(w, "peer "+key+" via "+address+"\n")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := dw.zip.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range r.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, _ := io.ReadAll(f)
+		f.Close()
+		if s := string(contents); s != "peer <key-1> via <ipv4-1>\n" || strings.Contains(s, address) {
+			t.Errorf("%s was not redacted with the shared pseudonyms: %#q", file.Name, s)
+		}
+	}
+}
+
+func TestDiagnosticsRequiresElevation(t *testing.T) {
+	if _, err := (&ManagerService{}).Diagnostics(ringlogger.RedactKeys); err != windows.ERROR_ACCESS_DENIED {
+		t.Errorf("Expected unelevated callers to be denied, but got %v", err)
+	}
+}
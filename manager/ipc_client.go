@@ -10,7 +10,9 @@ import (
 	"errors"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
 	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
 	"github.com/amnezia-vpn/amneziawg-windows/conf"
 )
@@ -37,6 +39,7 @@ const (
 	ManagerStoppingNotificationType
 	UpdateFoundNotificationType
 	UpdateProgressNotificationType
+	LogVerbosityChangeNotificationType
 )
 
 type MethodType int
@@ -55,6 +58,13 @@ const (
 	QuitMethodType
 	UpdateStateMethodType
 	UpdateMethodType
+	DiagnosticsMethodType
+	LogArchiveMethodType
+	LogVerbosityMethodType
+	SetLogVerbosityMethodType
+	CrashesMethodType
+	UpdateChannelMethodType
+	SetUpdateChannelMethodType
 )
 
 var (
@@ -82,7 +92,7 @@ type ManagerStoppingCallback struct {
 var managerStoppingCallbacks = make(map[*ManagerStoppingCallback]bool)
 
 type UpdateFoundCallback struct {
-	cb func(updateState UpdateState)
+	cb func(updateState UpdateState, notes updater.ReleaseNotes)
 }
 
 var updateFoundCallbacks = make(map[*UpdateFoundCallback]bool)
@@ -93,6 +103,12 @@ type UpdateProgressCallback struct {
 
 var updateProgressCallbacks = make(map[*UpdateProgressCallback]bool)
 
+type LogVerbosityChangeCallback struct {
+	cb func(verbosity *ringlogger.Verbosity)
+}
+
+var logVerbosityChangeCallbacks = make(map[*LogVerbosityChangeCallback]bool)
+
 func InitializeIPCClient(reader, writer, events *os.File) {
 	rpcDecoder = gob.NewDecoder(reader)
 	rpcEncoder = gob.NewEncoder(writer)
@@ -151,8 +167,13 @@ func InitializeIPCClient(reader, writer, events *os.File) {
 				if err != nil {
 					continue
 				}
+				var notes updater.ReleaseNotes
+				err = decoder.Decode(&notes)
+				if err != nil {
+					continue
+				}
 				for cb := range updateFoundCallbacks {
-					cb.cb(state)
+					cb.cb(state, notes)
 				}
 			case UpdateProgressNotificationType:
 				var dp updater.DownloadProgress
@@ -180,9 +201,26 @@ func InitializeIPCClient(reader, writer, events *os.File) {
 				if err != nil {
 					continue
 				}
+				err = decoder.Decode(&dp.BytesPerSecond)
+				if err != nil {
+					continue
+				}
+				err = decoder.Decode(&dp.Remaining)
+				if err != nil {
+					continue
+				}
 				for cb := range updateProgressCallbacks {
 					cb.cb(dp)
 				}
+			case LogVerbosityChangeNotificationType:
+				var verbosity ringlogger.Verbosity
+				err = decoder.Decode(&verbosity)
+				if err != nil {
+					continue
+				}
+				for cb := range logVerbosityChangeCallbacks {
+					cb.cb(&verbosity)
+				}
 			}
 		}
 	}()
@@ -409,7 +447,7 @@ func IPCClientQuit(stopTunnelsOnQuit bool) (alreadyQuit bool, err error) {
 	return
 }
 
-func IPCClientUpdateState() (updateState UpdateState, err error) {
+func IPCClientUpdateState() (updateState UpdateState, notes updater.ReleaseNotes, err error) {
 	rpcMutex.Lock()
 	defer rpcMutex.Unlock()
 
@@ -421,6 +459,10 @@ func IPCClientUpdateState() (updateState UpdateState, err error) {
 	if err != nil {
 		return
 	}
+	err = rpcDecoder.Decode(&notes)
+	if err != nil {
+		return
+	}
 	return
 }
 
@@ -431,6 +473,129 @@ func IPCClientUpdate() error {
 	return rpcEncoder.Encode(UpdateMethodType)
 }
 
+func IPCClientDiagnostics(redaction ringlogger.RedactionLevel) (diagnostics []byte, err error) {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err = rpcEncoder.Encode(DiagnosticsMethodType)
+	if err != nil {
+		return
+	}
+	err = rpcEncoder.Encode(redaction)
+	if err != nil {
+		return
+	}
+	err = rpcDecoder.Decode(&diagnostics)
+	if err != nil {
+		return
+	}
+	err = rpcDecodeError()
+	return
+}
+
+func IPCClientLogArchive(before time.Time, limit int) (lines []ringlogger.FollowLine, err error) {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err = rpcEncoder.Encode(LogArchiveMethodType)
+	if err != nil {
+		return
+	}
+	err = rpcEncoder.Encode(before)
+	if err != nil {
+		return
+	}
+	err = rpcEncoder.Encode(limit)
+	if err != nil {
+		return
+	}
+	err = rpcDecoder.Decode(&lines)
+	if err != nil {
+		return
+	}
+	err = rpcDecodeError()
+	return
+}
+
+func IPCClientLogVerbosity() (verbosity *ringlogger.Verbosity, err error) {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err = rpcEncoder.Encode(LogVerbosityMethodType)
+	if err != nil {
+		return
+	}
+	verbosity = &ringlogger.Verbosity{}
+	err = rpcDecoder.Decode(verbosity)
+	return
+}
+
+func IPCClientSetLogVerbosity(tag, tunnel string, level ringlogger.Level) error {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err := rpcEncoder.Encode(SetLogVerbosityMethodType)
+	if err != nil {
+		return err
+	}
+	err = rpcEncoder.Encode(tag)
+	if err != nil {
+		return err
+	}
+	err = rpcEncoder.Encode(tunnel)
+	if err != nil {
+		return err
+	}
+	err = rpcEncoder.Encode(level)
+	if err != nil {
+		return err
+	}
+	return rpcDecodeError()
+}
+
+func IPCClientCrashes() (crashes []string, err error) {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err = rpcEncoder.Encode(CrashesMethodType)
+	if err != nil {
+		return
+	}
+	err = rpcDecoder.Decode(&crashes)
+	return
+}
+
+func IPCClientUpdateChannel() (channel updater.Channel, byAdmin bool, err error) {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err = rpcEncoder.Encode(UpdateChannelMethodType)
+	if err != nil {
+		return
+	}
+	err = rpcDecoder.Decode(&channel)
+	if err != nil {
+		return
+	}
+	err = rpcDecoder.Decode(&byAdmin)
+	return
+}
+
+func IPCClientSetUpdateChannel(channel updater.Channel) error {
+	rpcMutex.Lock()
+	defer rpcMutex.Unlock()
+
+	err := rpcEncoder.Encode(SetUpdateChannelMethodType)
+	if err != nil {
+		return err
+	}
+	err = rpcEncoder.Encode(channel)
+	if err != nil {
+		return err
+	}
+	return rpcDecodeError()
+}
+
 func IPCClientRegisterTunnelChange(cb func(tunnel *Tunnel, state, globalState TunnelState, err error)) *TunnelChangeCallback {
 	s := &TunnelChangeCallback{cb}
 	tunnelChangeCallbacks[s] = true
@@ -461,7 +626,7 @@ func (cb *ManagerStoppingCallback) Unregister() {
 	delete(managerStoppingCallbacks, cb)
 }
 
-func IPCClientRegisterUpdateFound(cb func(updateState UpdateState)) *UpdateFoundCallback {
+func IPCClientRegisterUpdateFound(cb func(updateState UpdateState, notes updater.ReleaseNotes)) *UpdateFoundCallback {
 	s := &UpdateFoundCallback{cb}
 	updateFoundCallbacks[s] = true
 	return s
@@ -480,3 +645,13 @@ func IPCClientRegisterUpdateProgress(cb func(dp updater.DownloadProgress)) *Upda
 func (cb *UpdateProgressCallback) Unregister() {
 	delete(updateProgressCallbacks, cb)
 }
+
+func IPCClientRegisterLogVerbosityChange(cb func(verbosity *ringlogger.Verbosity)) *LogVerbosityChangeCallback {
+	s := &LogVerbosityChangeCallback{cb}
+	logVerbosityChangeCallbacks[s] = true
+	return s
+}
+
+func (cb *LogVerbosityChangeCallback) Unregister() {
+	delete(logVerbosityChangeCallbacks, cb)
+}
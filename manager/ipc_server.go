@@ -8,6 +8,7 @@ package manager
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -19,6 +20,7 @@ import (
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
 
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
 	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
 	"github.com/amnezia-vpn/amneziawg-windows/conf"
 	"github.com/amnezia-vpn/amneziawg-windows/services"
@@ -269,14 +271,18 @@ func (s *ManagerService) Quit(stopTunnelsOnQuit bool) (alreadyQuit bool, err err
 	return false, nil
 }
 
-func (s *ManagerService) UpdateState() UpdateState {
-	return updateState
+func (s *ManagerService) UpdateState() (UpdateState, updater.ReleaseNotes) {
+	return currentUpdate()
 }
 
 func (s *ManagerService) Update() {
 	if s.elevatedToken == 0 {
 		return
 	}
+	if policy, _, _ := updater.CurrentPolicy(); policy == updater.PolicyDisabled {
+		IPCServerNotifyUpdateProgress(updater.DownloadProgress{Error: errors.New("Updates are disabled by the administrator")})
+		return
+	}
 	progress := updater.DownloadVerifyAndExecute(uintptr(s.elevatedToken))
 	go func() {
 		for {
@@ -289,6 +295,74 @@ func (s *ManagerService) Update() {
 	}()
 }
 
+// LogArchive returns up to limit of the archived log lines preceding before, or
+// none if the log is not archived.
+func (s *ManagerService) LogArchive(before time.Time, limit int) ([]ringlogger.FollowLine, error) {
+	if limit < 0 || limit > ringlogger.MaxTailLines {
+		return nil, errors.New("Invalid number of archived log lines")
+	}
+	if len(logArchiveDirectory) == 0 {
+		return []ringlogger.FollowLine{}, nil
+	}
+	return ringlogger.TailArchive(logArchiveDirectory, before, limit)
+}
+
+func (s *ManagerService) LogVerbosity() ringlogger.Verbosity {
+	if v := ringlogger.CurrentVerbosity(); v != nil {
+		return *v
+	}
+	return ringlogger.Verbosity{}
+}
+
+// SetLogVerbosity sets the most verbose level logged by the component with the
+// given tag, or by tunnel if tag is empty, until reset by a level of zero.
+// Giving neither resets all levels.
+func (s *ManagerService) SetLogVerbosity(tag, tunnel string, level ringlogger.Level) error {
+	if s.elevatedToken == 0 {
+		return windows.ERROR_ACCESS_DENIED
+	}
+	return setLogVerbosity(tag, tunnel, level)
+}
+
+// UpdateChannel returns the channel updates are taken from, and whether it was
+// set by the administrator.
+func (s *ManagerService) UpdateChannel() (updater.Channel, bool) {
+	channel, byAdmin, _ := updater.CurrentChannel()
+	return channel, byAdmin
+}
+
+// SetUpdateChannel switches the channel updates are taken from, and checks it
+// for an update right away.
+func (s *ManagerService) SetUpdateChannel(channel updater.Channel) error {
+	if s.elevatedToken == 0 {
+		return windows.ERROR_ACCESS_DENIED
+	}
+	err := updater.SetChannel(channel)
+	if err != nil {
+		return err
+	}
+	logUpdateSource()
+	recheckForUpdates()
+	return nil
+}
+
+// Crashes returns the names of the crash files found since the manager started.
+func (s *ManagerService) Crashes() []string {
+	return currentCrashFiles()
+}
+
+func (s *ManagerService) Diagnostics(redaction ringlogger.RedactionLevel) ([]byte, error) {
+	if s.elevatedToken == 0 {
+		return nil, windows.ERROR_ACCESS_DENIED
+	}
+	var buf bytes.Buffer
+	err := WriteDiagnostics(&buf, redaction)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func (s *ManagerService) ServeConn(reader io.Reader, writer io.Writer) {
 	decoder := gob.NewDecoder(reader)
 	encoder := gob.NewEncoder(writer)
@@ -444,13 +518,106 @@ func (s *ManagerService) ServeConn(reader io.Reader, writer io.Writer) {
 				return
 			}
 		case UpdateStateMethodType:
-			updateState := s.UpdateState()
+			updateState, notes := s.UpdateState()
 			err = encoder.Encode(updateState)
 			if err != nil {
 				return
 			}
+			err = encoder.Encode(notes)
+			if err != nil {
+				return
+			}
 		case UpdateMethodType:
 			s.Update()
+		case DiagnosticsMethodType:
+			var redaction ringlogger.RedactionLevel
+			err := decoder.Decode(&redaction)
+			if err != nil {
+				return
+			}
+			diagnostics, retErr := s.Diagnostics(redaction)
+			err = encoder.Encode(diagnostics)
+			if err != nil {
+				return
+			}
+			err = encoder.Encode(errToString(retErr))
+			if err != nil {
+				return
+			}
+		case LogArchiveMethodType:
+			var before time.Time
+			err := decoder.Decode(&before)
+			if err != nil {
+				return
+			}
+			var limit int
+			err = decoder.Decode(&limit)
+			if err != nil {
+				return
+			}
+			lines, retErr := s.LogArchive(before, limit)
+			if lines == nil {
+				lines = []ringlogger.FollowLine{}
+			}
+			err = encoder.Encode(lines)
+			if err != nil {
+				return
+			}
+			err = encoder.Encode(errToString(retErr))
+			if err != nil {
+				return
+			}
+		case LogVerbosityMethodType:
+			err = encoder.Encode(s.LogVerbosity())
+			if err != nil {
+				return
+			}
+		case SetLogVerbosityMethodType:
+			var tag, tunnel string
+			err := decoder.Decode(&tag)
+			if err != nil {
+				return
+			}
+			err = decoder.Decode(&tunnel)
+			if err != nil {
+				return
+			}
+			var level ringlogger.Level
+			err = decoder.Decode(&level)
+			if err != nil {
+				return
+			}
+			retErr := s.SetLogVerbosity(tag, tunnel, level)
+			err = encoder.Encode(errToString(retErr))
+			if err != nil {
+				return
+			}
+		case CrashesMethodType:
+			err = encoder.Encode(s.Crashes())
+			if err != nil {
+				return
+			}
+		case UpdateChannelMethodType:
+			channel, byAdmin := s.UpdateChannel()
+			err = encoder.Encode(channel)
+			if err != nil {
+				return
+			}
+			err = encoder.Encode(byAdmin)
+			if err != nil {
+				return
+			}
+		case SetUpdateChannelMethodType:
+			var channel updater.Channel
+			err := decoder.Decode(&channel)
+			if err != nil {
+				return
+			}
+			retErr := s.SetUpdateChannel(channel)
+			err = encoder.Encode(errToString(retErr))
+			if err != nil {
+				return
+			}
 		default:
 			return
 		}
@@ -527,12 +694,19 @@ func IPCServerNotifyTunnelsChange() {
 	notifyAll(TunnelsChangeNotificationType, false)
 }
 
-func IPCServerNotifyUpdateFound(state UpdateState) {
-	notifyAll(UpdateFoundNotificationType, false, state)
+func IPCServerNotifyUpdateFound(state UpdateState, notes updater.ReleaseNotes) {
+	notifyAll(UpdateFoundNotificationType, false, state, notes)
 }
 
 func IPCServerNotifyUpdateProgress(dp updater.DownloadProgress) {
-	notifyAll(UpdateProgressNotificationType, true, dp.Activity, dp.BytesDownloaded, dp.BytesTotal, errToString(dp.Error), dp.Complete)
+	notifyAll(UpdateProgressNotificationType, true, dp.Activity, dp.BytesDownloaded, dp.BytesTotal, errToString(dp.Error), dp.Complete, dp.BytesPerSecond, dp.Remaining)
+}
+
+func IPCServerNotifyLogVerbosityChange(verbosity *ringlogger.Verbosity) {
+	if verbosity == nil {
+		verbosity = &ringlogger.Verbosity{}
+	}
+	notifyAll(LogVerbosityChangeNotificationType, false, verbosity)
 }
 
 func IPCServerNotifyManagerStopping() {
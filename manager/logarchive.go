@@ -0,0 +1,58 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+	"github.com/amnezia-vpn/amneziawg-windows/conf"
+)
+
+const defaultLogArchiveMaxSize = 100 // MiB
+
+// logArchiveDirectory is set while the archiver is running.
+var logArchiveDirectory string
+
+func LogArchiveDirectory(createRoot bool) (string, error) {
+	root, err := conf.RootDirectory(createRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "log-archive"), nil
+}
+
+// startLogArchiver starts archiving the global log if the admin enabled it,
+// returning nil otherwise.
+func startLogArchiver() *ringlogger.Archiver {
+	days, ok := services.AdminKeyInteger("LogArchiveDays")
+	if !ok || days == 0 {
+		return nil
+	}
+	maxSize, ok := services.AdminKeyInteger("LogArchiveMaxSize")
+	if !ok || maxSize == 0 {
+		maxSize = defaultLogArchiveMaxSize
+	}
+	dir, err := LogArchiveDirectory(true)
+	if err != nil {
+		log.Printf("Unable to determine log archive directory: %v", err)
+		return nil
+	}
+	archiver, err := ringlogger.StartArchiver(ringlogger.Global, dir, ringlogger.Retention{
+		MaxAge:  time.Duration(days) * 24 * time.Hour,
+		MaxSize: int64(maxSize) << 20,
+	})
+	if err != nil {
+		log.Printf("Unable to start log archiver: %v", err)
+		return nil
+	}
+	log.Printf("Archiving log to %s for %d days", dir, days)
+	logArchiveDirectory = dir
+	return archiver
+}
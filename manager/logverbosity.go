@@ -0,0 +1,142 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows/conf"
+)
+
+// The log verbosity is kept in a file next to the log, so that it lasts until
+// it is reset. It applies to the lines that the manager writes and to those of
+// the tunnel services that it copies into its log.
+type logVerbosityFile struct {
+	Components map[string]string `json:"components,omitempty"`
+	Tunnels    map[string]string `json:"tunnels,omitempty"`
+}
+
+var logVerbosityLock sync.Mutex
+
+func logVerbosityPath(createRoot bool) (string, error) {
+	root, err := conf.RootDirectory(createRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "log-verbosity.json"), nil
+}
+
+// loadLogVerbosity reads the persisted verbosity, which is nil if there is
+// none. Levels that cannot be parsed are ignored.
+func loadLogVerbosity() (*ringlogger.Verbosity, error) {
+	path, err := logVerbosityPath(false)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file logVerbosityFile
+	err = json.Unmarshal(bytes, &file)
+	if err != nil {
+		return nil, err
+	}
+	var v *ringlogger.Verbosity
+	for tag, s := range file.Components {
+		if level, err := ringlogger.ParseLevel(s); err == nil {
+			v = v.With(tag, "", level)
+		}
+	}
+	for tunnel, s := range file.Tunnels {
+		if level, err := ringlogger.ParseLevel(s); err == nil {
+			v = v.With("", tunnel, level)
+		}
+	}
+	return v, nil
+}
+
+func saveLogVerbosity(v *ringlogger.Verbosity) error {
+	path, err := logVerbosityPath(true)
+	if err != nil {
+		return err
+	}
+	if v == nil || (len(v.Components) == 0 && len(v.Tunnels) == 0) {
+		err = os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	file := logVerbosityFile{Components: make(map[string]string), Tunnels: make(map[string]string)}
+	for tag, level := range v.Components {
+		file.Components[tag] = level.String()
+	}
+	for tunnel, level := range v.Tunnels {
+		file.Tunnels[tunnel] = level.String()
+	}
+	bytes, err := json.MarshalIndent(&file, "", "\t")
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, bytes, 0o600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// setLogVerbosity sets the most verbose level logged by the component with the
+// given tag, or by tunnel if tag is empty, or resets it if level is zero. If
+// both are empty, all levels are reset.
+func setLogVerbosity(tag, tunnel string, level ringlogger.Level) error {
+	logVerbosityLock.Lock()
+	defer logVerbosityLock.Unlock()
+	if level > ringlogger.LevelVerbose {
+		return errors.New("Invalid log level")
+	}
+	var v *ringlogger.Verbosity
+	if len(tag) > 0 || len(tunnel) > 0 {
+		v = ringlogger.CurrentVerbosity().With(tag, tunnel, level)
+	} else if level != 0 {
+		return errors.New("No component or tunnel given")
+	}
+	err := saveLogVerbosity(v)
+	if err != nil {
+		return err
+	}
+	ringlogger.SetVerbosity(v)
+	log.Printf("Log verbosity set to %v", v)
+	IPCServerNotifyLogVerbosityChange(v)
+	return nil
+}
+
+// restoreLogVerbosity applies the persisted verbosity to the manager.
+func restoreLogVerbosity() {
+	v, err := loadLogVerbosity()
+	if err != nil {
+		log.Printf("Unable to read log verbosity: %v", err)
+		return
+	}
+	if v != nil {
+		ringlogger.SetVerbosity(v)
+		log.Printf("Log verbosity is %v", v)
+	}
+}
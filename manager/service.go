@@ -7,6 +7,7 @@ package manager
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -42,19 +43,39 @@ func (service *managerService) Execute(args []string, r <-chan svc.ChangeRequest
 		changes <- svc.Status{State: svc.StopPending}
 	}()
 
+	started := time.Now()
 	var logFile string
 	logFile, err = LogFile(true)
 	if err != nil {
 		serviceError = services.ErrorRingloggerOpen
 		return
 	}
-	err = ringlogger.InitGlobalLogger(logFile, "MGR")
+	geometry, geometryErr := logGeometry()
+	err = ringlogger.InitGlobalLoggerWithGeometry(logFile, "MGR", &geometry)
 	if err != nil {
 		serviceError = services.ErrorRingloggerOpen
 		return
 	}
+	if geometryErr != nil {
+		log.Printf("Using default log size: %v", geometryErr)
+	}
 
 	services.PrintStarting()
+	CaptureCrashes("MGR")
+	collectCrashes()
+	restoreLogVerbosity()
+
+	if archiver := startLogArchiver(); archiver != nil {
+		defer archiver.Close()
+	}
+	if forwarder := startSyslogForwarder(started); forwarder != nil {
+		defer forwarder.Close()
+	}
+	// Closed first, so that the last lines of the tunnel services are
+	// archived and forwarded too.
+	if importer := startTunnelLogImporter(); importer != nil {
+		defer importer.Close()
+	}
 
 	path, err := os.Executable()
 	if err != nil {
@@ -353,10 +374,38 @@ func Run() error {
 	return svc.Run("AmneziaWGManager", &managerService{})
 }
 
+// LogFile returns the path of the log of the manager, which only the manager
+// writes, so that it alone decides its format and geometry.
 func LogFile(createRoot bool) (string, error) {
+	root, err := conf.RootDirectory(createRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "manager-log.bin"), nil
+}
+
+// TunnelLogFile returns the path of the log that the tunnel services write
+// through the tunnel library, which is of the legacy format.
+func TunnelLogFile(createRoot bool) (string, error) {
 	root, err := conf.RootDirectory(createRoot)
 	if err != nil {
 		return "", err
 	}
 	return filepath.Join(root, "log.bin"), nil
 }
+
+// logGeometry returns the size of the log configured by the admin, or the
+// default size if none or an invalid one is configured.
+func logGeometry() (ringlogger.Geometry, error) {
+	geometry := ringlogger.DefaultGeometry
+	if lines, ok := services.AdminKeyInteger("LogLines"); ok {
+		geometry.Lines = lines
+	}
+	if lineLength, ok := services.AdminKeyInteger("LogLineLength"); ok {
+		geometry.LineLength = lineLength
+	}
+	if !geometry.Valid() {
+		return ringlogger.DefaultGeometry, fmt.Errorf("invalid configured size of %v", geometry)
+	}
+	return geometry, nil
+}
@@ -0,0 +1,45 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-ip_address_prefix
+type ipAddressPrefix struct {
+	prefix       windows.RawSockaddrInet6 // SOCKADDR_INET
+	prefixLength uint8
+	_            [3]byte
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-mib_ipforward_row2
+type mibIPforwardRow2 struct {
+	interfaceLUID        uint64
+	interfaceIndex       uint32
+	destinationPrefix    ipAddressPrefix
+	nextHop              windows.RawSockaddrInet6 // SOCKADDR_INET
+	sitePrefixLength     uint8
+	validLifetime        uint32
+	preferredLifetime    uint32
+	metric               uint32
+	protocol             uint32
+	loopback             bool
+	autoconfigureAddress bool
+	publish              bool
+	immortal             bool
+	age                  uint32
+	origin               uint32
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-mib_ipforward_table2
+type mibIPforwardTable2 struct {
+	numEntries uint32
+	table      [1]mibIPforwardRow2
+}
+
+//sys	getIPForwardTable2(family uint16, table **mibIPforwardTable2) (ret error) = iphlpapi.GetIpForwardTable2
+//sys	freeMibTable(memory uintptr) = iphlpapi.FreeMibTable
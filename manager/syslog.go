@@ -0,0 +1,31 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"log"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+)
+
+// startSyslogForwarder starts forwarding the global log lines written since
+// the given time to the syslog server configured by the admin, returning nil
+// if there is none.
+func startSyslogForwarder(since time.Time) *ringlogger.SyslogForwarder {
+	server := services.AdminKeyString("SyslogServer")
+	if len(server) == 0 {
+		return nil
+	}
+	forwarder, err := ringlogger.StartSyslogForwarder(ringlogger.Global, server, since)
+	if err != nil {
+		log.Printf("Unable to forward log to syslog server: %v", err)
+		return nil
+	}
+	log.Printf("Forwarding log to syslog server %s", server)
+	return forwarder
+}
@@ -0,0 +1,25 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"log"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+)
+
+// startTunnelLogImporter starts copying the lines that the tunnel services
+// write to their log into the global log, returning nil if it cannot.
+func startTunnelLogImporter() *ringlogger.Importer {
+	path, err := TunnelLogFile(false)
+	if err != nil {
+		log.Printf("Unable to determine tunnel log file: %v", err)
+		return nil
+	}
+	return ringlogger.StartImporter(ringlogger.Global, func() (*ringlogger.Ringlogger, error) {
+		return ringlogger.NewRingloggerForReading(path, "IMP")
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/mgr"
 
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
 	"github.com/amnezia-vpn/amneziawg-windows/conf"
 	"github.com/amnezia-vpn/amneziawg-windows/services"
 )
@@ -221,6 +222,7 @@ func trackTunnelService(tunnelName string, service *mgr.Service) {
 	}
 	lastState := TunnelUnknown
 	err := trackService(service, func(status uint32) bool {
+		ringlogger.Logf(ringlogger.LevelVerbose, tunnelName, "Tunnel service status notification %#x", status)
 		state := notifyStateToTunState(status)
 		var tunnelError error
 		if state == TunnelStopped {
@@ -242,6 +244,7 @@ func trackTunnelService(tunnelName string, service *mgr.Service) {
 			if tunnelError != nil {
 				service.Delete()
 			}
+			collectCrashes()
 		}
 		if state != lastState {
 			trackedTunnelsLock.Lock()
@@ -7,9 +7,12 @@ package manager
 
 import (
 	"log"
+	"sync"
 	"time"
 	_ "unsafe"
 
+	"golang.org/x/sys/windows/svc"
+
 	"github.com/amnezia-vpn/amneziawg-windows-client/services"
 	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
 	"github.com/amnezia-vpn/amneziawg-windows-client/version"
@@ -24,42 +27,314 @@ const (
 	UpdateStateUnknown UpdateState = iota
 	UpdateStateFoundUpdate
 	UpdateStateUpdatesDisabledUnofficialBuild
+	UpdateStateUpdatesDisabledByPolicy
+	UpdateStateDownloadedUpdate
+	UpdateStateInstallingUpdate
+	UpdateStateRolledBackUpdate
+	UpdateStateFailedUpdate
+)
+
+// updateState and updateNotes, the release notes of the update found, if it
+// has any, are set by checkForUpdates and read by IPC clients, so are guarded
+// by updateStateLock.
+var (
+	updateStateLock sync.Mutex
+	updateState     = UpdateStateUnknown
+	updateNotes     updater.ReleaseNotes
 )
 
-var updateState = UpdateStateUnknown
+// updateRecheck wakes checkForUpdates to check again right away.
+var updateRecheck = make(chan struct{}, 1)
+
+// waitForUpdateCheck sleeps for a random duration between min and max, unless
+// woken by recheckForUpdates, which it returns whether it was.
+func waitForUpdateCheck(min, max time.Duration) bool {
+	timer := time.NewTimer(min + time.Millisecond*time.Duration(fastrandn(uint32((max-min+1)/time.Millisecond))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-updateRecheck:
+		return true
+	}
+}
+
+func setUpdateState(state UpdateState) {
+	updateStateLock.Lock()
+	defer updateStateLock.Unlock()
+	updateState = state
+	IPCServerNotifyUpdateFound(updateState, updateNotes)
+}
+
+// setUpdateStateWithNotes is like setUpdateState, but replaces the release
+// notes too.
+func setUpdateStateWithNotes(state UpdateState, notes updater.ReleaseNotes) {
+	updateStateLock.Lock()
+	defer updateStateLock.Unlock()
+	updateState, updateNotes = state, notes
+	IPCServerNotifyUpdateFound(updateState, updateNotes)
+}
+
+// currentUpdate returns the state maintained by checkForUpdates, along with
+// the release notes of the update found.
+func currentUpdate() (UpdateState, updater.ReleaseNotes) {
+	updateStateLock.Lock()
+	defer updateStateLock.Unlock()
+	return updateState, updateNotes
+}
+
+// setUpdateFound notes the release notes of update, logging why it has none
+// if the signed list has some, and reports that it was found.
+func setUpdateFound(update *updater.UpdateFound) {
+	notes, err := update.Notes()
+	if err != nil {
+		log.Printf("Unable to read the release notes of version %v: %v", update.Version(), err)
+	}
+	var found updater.ReleaseNotes
+	if notes != nil {
+		found = *notes
+		log.Printf("Version %v is a %s release from %s", update.Version(), notes.Severity, notes.Date.Format(time.DateOnly))
+	}
+	setUpdateStateWithNotes(foundUpdateState(update), found)
+}
+
+// failedToInstall returns how installing update failed before, if it did.
+func failedToInstall(update *updater.UpdateFound) *updater.InstallFailure {
+	failure, err := updater.LastInstallFailure()
+	if err != nil || failure == nil || failure.To != update.Version().String() {
+		return nil
+	}
+	return failure
+}
 
-func jitterSleep(min, max time.Duration) {
-	time.Sleep(min + time.Millisecond*time.Duration(fastrandn(uint32((max-min+1)/time.Millisecond))))
+// foundUpdateState is the state of having found update, which says if
+// installing it failed before, so that it is not installed automatically
+// again, and the UI can tell.
+func foundUpdateState(update *updater.UpdateFound) UpdateState {
+	failure := failedToInstall(update)
+	switch {
+	case failure == nil:
+		return UpdateStateFoundUpdate
+	case failure.RolledBack:
+		return UpdateStateRolledBackUpdate
+	default:
+		return UpdateStateFailedUpdate
+	}
+}
+
+// recoverFailedUpdate reinstalls the version that the update installed last
+// replaced, if installing it failed, which restarts the manager.
+func recoverFailedUpdate() {
+	failure, err := updater.RecoverFailedInstall()
+	switch {
+	case failure == nil && err != nil:
+		log.Printf("Update checker: unable to check the last update installed: %v", err)
+	case failure == nil:
+	case err != nil:
+		log.Printf("Installing the update to version %s failed, and so did rolling back to version %s: %v", failure.To, failure.From, err)
+	default:
+		log.Printf("Installing the update to version %s failed, so version %s was reinstalled", failure.To, failure.From)
+	}
+}
+
+// waitForMaintenanceWindow waits for up to an hour for the maintenance window
+// to open while no tunnel is active, and returns whether it did.
+func waitForMaintenanceWindow(window updater.MaintenanceWindow) bool {
+	deadline := time.Now().Add(time.Hour)
+	for time.Now().Before(deadline) {
+		if window.Contains(time.Now()) && trackedTunnelsGlobalState() == TunnelStopped {
+			return true
+		}
+		if waitForUpdateCheck(time.Minute, time.Minute) {
+			return false
+		}
+	}
+	return false
+}
+
+// installUpdate installs the update downloaded before, as SYSTEM, and relays
+// its progress to any UI listening.
+func installUpdate() error {
+	setUpdateState(UpdateStateInstallingUpdate)
+	progress := updater.DownloadVerifyAndExecute(0)
+	for {
+		dp := <-progress
+		IPCServerNotifyUpdateProgress(dp)
+		if dp.Error != nil {
+			return dp.Error
+		}
+		if dp.Complete {
+			return nil
+		}
+	}
+}
+
+func recheckForUpdates() {
+	select {
+	case updateRecheck <- struct{}{}:
+	default:
+	}
+}
+
+func logUpdateSource() {
+	source, err := updater.CurrentSource()
+	if err != nil {
+		log.Printf("Update checker: %v", err)
+		return
+	}
+	channel, byAdmin, err := updater.CurrentChannel()
+	if err != nil {
+		log.Printf("Update checker: %v", err)
+		return
+	}
+	if byAdmin {
+		log.Printf("Checking for updates in the %s channel, set by the administrator, at %s", channel, source.URL(channel))
+	} else {
+		log.Printf("Checking for updates in the %s channel at %s", channel, source.URL(channel))
+	}
+}
+
+// logUpdateProxy logs the proxy that update checks go through, which may take
+// running a proxy auto-config script, so it is not done by logUpdateSource.
+func logUpdateProxy() {
+	source, err := updater.CurrentSource()
+	if err != nil {
+		return
+	}
+	channel, _, err := updater.CurrentChannel()
+	if err != nil {
+		return
+	}
+	proxy, err := updater.ProxyFor(source, channel)
+	if err != nil {
+		log.Printf("Update checker: unable to determine proxy: %v", err)
+		return
+	}
+	log.Printf("Checking for updates through %s", proxy)
 }
 
 func checkForUpdates() {
 	if !version.IsRunningOfficialVersion() {
 		log.Println("Build is not official, so updates are disabled")
-		updateState = UpdateStateUpdatesDisabledUnofficialBuild
-		IPCServerNotifyUpdateFound(updateState)
+		setUpdateState(UpdateStateUpdatesDisabledUnofficialBuild)
+		return
+	}
+	recoverFailedUpdate()
+	policy, window, err := updater.CurrentPolicy()
+	if err != nil {
+		log.Printf("Update checker: %v", err)
+	}
+	switch policy {
+	case updater.PolicyDisabled:
+		log.Println("Updates are disabled by the administrator")
+		setUpdateState(UpdateStateUpdatesDisabledByPolicy)
 		return
+	case updater.PolicyInstall:
+		log.Printf("Updates are installed automatically between %v, when no tunnel is active", window)
+	case updater.PolicyDownload:
+		log.Println("Updates are downloaded automatically")
 	}
+	logUpdateSource()
+	logUpdateProxy()
 	if services.StartedAtBoot() {
-		jitterSleep(time.Minute*2, time.Minute*5)
+		waitForUpdateCheck(time.Minute*2, time.Minute*5)
 	}
-	noError, didNotify := true, false
+	noError := true
+	var notified, prepared, installed *version.Version
 	for {
 		update, err := updater.CheckForUpdate()
-		if err == nil && update != nil && !didNotify {
-			log.Println("An update is available")
-			updateState = UpdateStateFoundUpdate
-			IPCServerNotifyUpdateFound(updateState)
-			didNotify = true
-		} else if err != nil && !didNotify {
+		if state, _ := currentUpdate(); err == nil && update == nil && state != UpdateStateUnknown {
+			// The channel was switched to one with nothing newer.
+			log.Println("An update is no longer available")
+			setUpdateStateWithNotes(UpdateStateUnknown, updater.ReleaseNotes{})
+			notified, prepared = nil, nil
+		}
+		if err == nil && update != nil && (notified == nil || notified.Compare(update.Version()) != 0) {
+			log.Printf("An update to version %v is available", update.Version())
+			setUpdateFound(update)
+			v := update.Version()
+			notified = &v
+		}
+		if err == nil && update != nil && policy >= updater.PolicyDownload && (prepared == nil || prepared.Compare(update.Version()) != 0) && (installed == nil || installed.Compare(update.Version()) != 0) && failedToInstall(update) == nil {
+			if _, prepareErr := updater.PrepareUpdate(); prepareErr != nil {
+				log.Printf("Unable to download update: %v", prepareErr)
+			} else {
+				log.Printf("Downloaded the update to version %v", update.Version())
+				v := update.Version()
+				prepared = &v
+				setUpdateState(UpdateStateDownloadedUpdate)
+			}
+		}
+		if err == nil && prepared != nil && policy == updater.PolicyInstall {
+			if waitForMaintenanceWindow(window) {
+				log.Printf("Installing the update to version %v in the maintenance window", prepared)
+				if installErr := installUpdate(); installErr != nil {
+					log.Printf("Unable to install update: %v", installErr)
+					setUpdateFound(update)
+					prepared = nil
+					waitForUpdateCheck(time.Minute*25, time.Minute*30)
+				} else {
+					// Installing usually restarts the manager, but if it did not,
+					// the same update must not be installed again.
+					installed, prepared = prepared, nil
+				}
+			}
+			continue
+		}
+		if err != nil && notified == nil {
 			log.Printf("Update checker: %v", err)
 			if noError {
-				jitterSleep(time.Minute*4, time.Minute*6)
+				waitForUpdateCheck(time.Minute*4, time.Minute*6)
 				noError = false
 			} else {
-				jitterSleep(time.Minute*25, time.Minute*30)
+				waitForUpdateCheck(time.Minute*25, time.Minute*30)
 			}
 		} else {
-			jitterSleep(time.Hour-time.Minute*3, time.Hour+time.Minute*3)
+			waitForUpdateCheck(time.Hour-time.Minute*3, time.Hour+time.Minute*3)
+		}
+	}
+}
+
+func (s UpdateState) String() string {
+	switch s {
+	case UpdateStateFoundUpdate:
+		return "update found"
+	case UpdateStateUpdatesDisabledUnofficialBuild:
+		return "updates disabled for unofficial build"
+	case UpdateStateUpdatesDisabledByPolicy:
+		return "updates disabled by policy"
+	case UpdateStateDownloadedUpdate:
+		return "update downloaded"
+	case UpdateStateInstallingUpdate:
+		return "installing update"
+	case UpdateStateRolledBackUpdate:
+		return "update failed to install and was rolled back"
+	case UpdateStateFailedUpdate:
+		return "update failed to install"
+	default:
+		return "unknown"
+	}
+}
+
+// currentUpdateState returns the state maintained by checkForUpdates when
+// called from within the manager service, and otherwise what is known without
+// checking for an update, which is left to the manager.
+func currentUpdateState() UpdateState {
+	if isService, err := svc.IsWindowsService(); err == nil && isService {
+		state, _ := currentUpdate()
+		return state
+	}
+	if !version.IsRunningOfficialVersion() {
+		return UpdateStateUpdatesDisabledUnofficialBuild
+	}
+	if policy, _, _ := updater.CurrentPolicy(); policy == updater.PolicyDisabled {
+		return UpdateStateUpdatesDisabledByPolicy
+	}
+	if failure, err := updater.LastInstallFailure(); err == nil && failure != nil {
+		if failure.RolledBack {
+			return UpdateStateRolledBackUpdate
 		}
+		return UpdateStateFailedUpdate
 	}
+	return UpdateStateUnknown
 }
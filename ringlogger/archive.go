@@ -0,0 +1,312 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archives are gzip compressed files of JSON lines, as written by
+// FollowLine.WriteJSON. A new file is started every day and every time the
+// archiver starts, and files are named after the time they were started, so
+// that sorting their names sorts them chronologically.
+const (
+	archivePrefix     = "log-"
+	archiveSuffix     = ".jsonl.gz"
+	archiveNameLayout = "20060102-150405"
+)
+
+// MaxTailLines is the most lines that TailArchive returns, which is as many as
+// the log window displays.
+const MaxTailLines = 10000
+
+// Retention limits how much an archive keeps. Files are removed, oldest first,
+// once they were last written longer ago than MaxAge or when all files
+// together exceed MaxSize bytes. Zero values impose no limit.
+type Retention struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// Archiver appends the lines of a ring to the archive in a directory, so that
+// they outlive the ring.
+type Archiver struct {
+	rl        *Ringlogger
+	dir       string
+	retention Retention
+	file      *os.File
+	gzip      *gzip.Writer
+	day       time.Time
+	last      time.Time // Stamp of the newest line archived before starting.
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// StartArchiver archives the lines of rl every second, starting with the
+// lines still in the ring that are newer than the archive.
+func StartArchiver(rl *Ringlogger, dir string, retention Retention) (*Archiver, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archiver{rl: rl, dir: dir, retention: retention, stop: make(chan struct{})}
+	names, err := archiveNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		readArchiveFile(filepath.Join(dir, names[len(names)-1]), func(line *FollowLine) bool {
+			if line.Stamp.After(a.last) {
+				a.last = line.Stamp
+			}
+			return true
+		})
+	}
+	a.stopped.Add(1)
+	go a.run()
+	return a, nil
+}
+
+func (a *Archiver) run() {
+	defer a.stopped.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	cursor := CursorAll
+	stopping := false
+	// Lines read but not yet archived, which are kept until appending them
+	// succeeds, though no more than the ring holds.
+	var pending []FollowLine
+	for first := true; ; first = false {
+		var lines []FollowLine
+		var missed uint64
+		lines, cursor, missed = a.rl.FollowFromCursor(cursor)
+		if missed > 0 {
+			pending = append(pending, MissedLine(missed))
+		}
+		for i := range lines {
+			// Only the lines still in the ring when starting may have been
+			// archived before. After that, the cursor says what is new, and
+			// stamps may well repeat or go back, as writers in other
+			// processes are not ordered and the clock is coarse.
+			if first && !lines[i].Stamp.After(a.last) {
+				continue
+			}
+			pending = append(pending, lines[i])
+		}
+		if dropped := len(pending) - int(a.rl.layout.Lines); dropped > 0 {
+			pending = append([]FollowLine{MissedLine(uint64(dropped + 1))}, pending[dropped+1:]...)
+		}
+		for len(pending) > 0 {
+			err := a.append(&pending[0])
+			if err != nil {
+				log.Printf("Unable to archive log: %v", err)
+				// A gzip stream that failed to write stays failed, so the
+				// next attempt starts a new file.
+				a.closeFile()
+				break
+			}
+			pending = pending[1:]
+		}
+		if a.gzip != nil {
+			a.gzip.Flush()
+		}
+		if stopping {
+			if len(pending) > 0 {
+				log.Printf("Unable to archive the last %d lines of the log", len(pending))
+			}
+			a.closeFile()
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-a.stop:
+			stopping = true
+		}
+	}
+}
+
+func (a *Archiver) append(line *FollowLine) error {
+	stamp := line.Stamp.Local()
+	day := time.Date(stamp.Year(), stamp.Month(), stamp.Day(), 0, 0, 0, 0, time.Local)
+	if a.gzip == nil || !day.Equal(a.day) {
+		a.closeFile()
+		file, err := os.OpenFile(filepath.Join(a.dir, archivePrefix+time.Now().Format(archiveNameLayout)+archiveSuffix), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		a.file, a.gzip, a.day = file, gzip.NewWriter(file), day
+		a.prune()
+	}
+	_, err := line.WriteJSON(a.gzip)
+	return err
+}
+
+func (a *Archiver) closeFile() {
+	if a.gzip != nil {
+		a.gzip.Close()
+		a.gzip = nil
+	}
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// prune removes the files exceeding the retention, except for the current one.
+func (a *Archiver) prune() {
+	names, err := archiveNames(a.dir)
+	if err != nil {
+		return
+	}
+	var total int64
+	for i := len(names) - 2; i >= 0; i-- {
+		path := filepath.Join(a.dir, names[i])
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		if (a.retention.MaxAge > 0 && time.Since(info.ModTime()) > a.retention.MaxAge) ||
+			(a.retention.MaxSize > 0 && total > a.retention.MaxSize) {
+			os.Remove(path)
+		}
+	}
+}
+
+// Close archives what is left in the ring and stops the archiver.
+func (a *Archiver) Close() error {
+	if a.stop != nil {
+		close(a.stop)
+		a.stopped.Wait()
+		a.stop = nil
+	}
+	return nil
+}
+
+func archiveNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), archivePrefix) && strings.HasSuffix(entry.Name(), archiveSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readArchiveFile calls fn for each line of an archive file until fn returns
+// false. The file currently being written is not yet terminated, so running
+// into its end is not an error.
+func readArchiveFile(path string, fn func(line *FollowLine) bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return true, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err == io.EOF {
+		return true, nil
+	} else if err != nil {
+		return true, err
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var jl jsonLine
+		if json.Unmarshal(scanner.Bytes(), &jl) != nil {
+			continue
+		}
+		level, _ := ParseLevel(jl.Level)
+		line := FollowLine{
+			Line:    formatLine(jl.Tag, jl.Tunnel, jl.Message),
+			Stamp:   jl.Timestamp,
+			Level:   level,
+			Tag:     jl.Tag,
+			Tunnel:  jl.Tunnel,
+			Message: jl.Message,
+		}
+		if !fn(&line) {
+			return false, nil
+		}
+	}
+	if err = scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return true, err
+	}
+	return true, nil
+}
+
+// ReadArchive calls fn for each archived line, oldest first, until fn returns
+// false. Files last written before since are skipped. A missing directory is
+// an empty archive.
+func ReadArchive(dir string, since time.Time, fn func(line *FollowLine) bool) error {
+	names, err := archiveNames(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		more, err := readArchiveFile(path, fn)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+// TailArchive returns up to limit of the last archived lines before the given
+// time, oldest first, and no more than MaxTailLines.
+func TailArchive(dir string, before time.Time, limit int) ([]FollowLine, error) {
+	lines := make([]FollowLine, 0)
+	limit = min(limit, MaxTailLines)
+	if limit <= 0 {
+		return lines, nil
+	}
+	names, err := archiveNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(names) - 1; i >= 0 && len(lines) < limit; i-- {
+		var fileLines []FollowLine
+		_, err = readArchiveFile(filepath.Join(dir, names[i]), func(line *FollowLine) bool {
+			if line.Stamp.Before(before) {
+				fileLines = append(fileLines, *line)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		lines = append(fileLines, lines...)
+	}
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines, nil
+}
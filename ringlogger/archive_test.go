@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestArchiveOutOfOrder(t *testing.T) {
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "ARC")
+	dir := t.TempDir()
+	stamp := time.Now().Add(-time.Minute).UnixNano()
+	rl.WriteWithTimestamp([]byte("first"), stamp)
+	archiver, err := StartArchiver(rl, dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writers in other processes and a coarse clock make stamps repeat and go
+	// back, which must not lose lines.
+	rl.WriteWithTimestamp([]byte("same stamp"), stamp)
+	rl.WriteWithTimestamp([]byte("earlier stamp"), stamp-int64(time.Second))
+	archiver.Close()
+
+	archiver, err = StartArchiver(rl, dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver.Close()
+	var messages []string
+	err = ReadArchive(dir, time.Time{}, func(line *FollowLine) bool {
+		messages = append(messages, line.Message)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[0] != "first" || messages[1] != "same stamp" || messages[2] != "earlier stamp" {
+		t.Errorf("Expected every line to be archived once, but got %q", messages)
+	}
+}
+
+func TestTailArchiveLimit(t *testing.T) {
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "ARC")
+	dir := t.TempDir()
+	stamp := time.Now().Add(-time.Minute).UnixNano()
+	for _, message := range []string{"one", "two", "three"} {
+		rl.WriteWithTimestamp([]byte(message), stamp)
+		stamp += int64(time.Millisecond)
+	}
+	archiver, err := StartArchiver(rl, dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver.Close()
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{-5, 0},
+		{0, 0},
+		{2, 2},
+		{MaxTailLines + 1, 3},
+		{int(^uint(0) >> 1), 3},
+	}
+	for _, test := range tests {
+		lines, err := TailArchive(dir, time.Now(), test.limit)
+		if err != nil {
+			t.Errorf("Limit %d: %v", test.limit, err)
+		} else if len(lines) != test.want || (test.want > 0 && lines[len(lines)-1].Message != "three") {
+			t.Errorf("Limit %d: expected %d lines ending with the newest, but got %+v", test.limit, test.want, lines)
+		}
+	}
+}
+
+func TestArchiveRetry(t *testing.T) {
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "ARC")
+	dir := t.TempDir()
+	archiver, err := StartArchiver(rl, dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lines that cannot be archived are kept until they can.
+	os.Remove(dir)
+	rl.Write([]byte("while failing"))
+	time.Sleep(time.Second * 3 / 2)
+	os.Mkdir(dir, 0o700)
+	rl.Write([]byte("after failing"))
+	archiver.Close()
+
+	var messages []string
+	err = ReadArchive(dir, time.Time{}, func(line *FollowLine) bool {
+		messages = append(messages, line.Message)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0] != "while failing" || messages[1] != "after failing" {
+		t.Errorf("Expected every line to be archived once, but got %q", messages)
+	}
+}
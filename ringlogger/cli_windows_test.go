@@ -0,0 +1,334 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThreads(t *testing.T) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		rl, err := NewRinglogger("ringlogger_test.bin", "ONE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1024; i++ {
+			fmt.Fprintf(rl, "bla bla bla %d", i)
+		}
+		rl.Close()
+		wg.Done()
+	}()
+	go func() {
+		rl, err := NewRinglogger("ringlogger_test.bin", "TWO")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1024; i < 2047; i++ {
+			fmt.Fprintf(rl, "bla bla bla %d", i)
+		}
+		rl.Close()
+		wg.Done()
+	}()
+	wg.Wait()
+}
+
+func TestWriteText(t *testing.T) {
+	rl, err := NewRinglogger("ringlogger_test.bin", "TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(os.Args) != 3 {
+		t.Fatal("Should pass exactly one argument")
+	}
+	fmt.Fprintf(rl, os.Args[2])
+	rl.Close()
+}
+
+func TestDump(t *testing.T) {
+	rl, err := NewRinglogger("ringlogger_test.bin", "DMP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rl.WriteTo(os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.Close()
+}
+
+func TestFollow(t *testing.T) {
+	rl, err := NewRinglogger("ringlogger_test.bin", "FOL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := CursorAll
+	for {
+		var lines []FollowLine
+		lines, cursor, _ = rl.FollowFromCursor(cursor)
+		for _, line := range lines {
+			fmt.Printf("%v: %s\n", line.Stamp, line.Line)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+}
+
+func TestWriteRecord(t *testing.T) {
+	rl, err := NewRinglogger("ringlogger_test.bin", "REC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	_, cursor, _ := rl.FollowFromCursor(CursorAll)
+	fmt.Fprintf(rl, "[some-tunnel] default level line")
+	rl.WriteRecord(LevelWarning, "other", []byte("warning line"), time.Now().UnixNano())
+	lines, _, _ := rl.FollowFromCursor(cursor)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, but got %d", len(lines))
+	}
+	if lines[0].Level != DefaultLevel || lines[0].Tag != "REC" || lines[0].Tunnel != "some-tunnel" || lines[0].Message != "default level line" {
+		t.Errorf("Unexpected first line: %+v", lines[0])
+	}
+	if lines[1].Level != LevelWarning || lines[1].Tunnel != "other" || lines[1].Line != "[REC] [other] warning line" {
+		t.Errorf("Unexpected second line: %+v", lines[1])
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	const filename = "ringlogger_geometry_test.bin"
+	defer os.Remove(filename)
+	small := Geometry{Lines: MinLines, LineLength: MinLineLength}
+	rl, err := NewRingloggerWithGeometry(filename, "GEO", &small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(rl, "line %d", i)
+	}
+	rl.Close()
+
+	rl, err = NewRinglogger(filename, "GEO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Geometry() != small {
+		t.Errorf("Expected existing geometry %v to be kept, but got %v", small, rl.Geometry())
+	}
+	rl.Close()
+
+	rl, err = NewRingloggerWithGeometry(filename, "GEO", &DefaultGeometry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	if rl.Geometry() != DefaultGeometry {
+		t.Fatalf("Expected geometry %v, but got %v", DefaultGeometry, rl.Geometry())
+	}
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != MinLines || lines[0].Message != "line 72" || lines[len(lines)-1].Message != "line 199" {
+		t.Errorf("Lines were not carried over: got %d lines", len(lines))
+	}
+}
+
+func TestLegacyConversion(t *testing.T) {
+	const filename = "ringlogger_legacy_test.bin"
+	defer os.Remove(filename)
+	l := layout{Geometry: DefaultGeometry, magic: legacyMagic, headerSize: 8, recordSize: legacyRecordOffsetLine + defaultLineLength}
+	legacy := make([]byte, l.fileSize())
+	binary.LittleEndian.PutUint32(legacy[offsetMagic:], legacyMagic)
+	binary.LittleEndian.PutUint32(legacy[offsetNextIndex:], 1)
+	binary.LittleEndian.PutUint64(legacy[l.recordOffset(1):], 1e9)
+	copy(legacy[l.recordOffset(1)+legacyRecordOffsetLine:], "[MGR] Starting")
+	err := os.WriteFile(filename, legacy, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An old writer still has the file open, so it must be left alone, and
+	// opening it must fail rather than drop lines.
+	writer, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, err := NewRinglogger(filename, "LEG")
+	writer.Close()
+	if err == nil {
+		rl.Close()
+		t.Fatal("Legacy file in use was opened for writing")
+	}
+	if current, _ := os.ReadFile(filename); !bytes.Equal(current, legacy) {
+		t.Fatal("Legacy file was changed while a writer had it open")
+	}
+
+	rl, err = NewRinglogger(filename, "LEG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	fmt.Fprint(rl, "converted")
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 2 || lines[0].Message != "Starting" || lines[1].Message != "converted" {
+		t.Errorf("Expected legacy lines to be converted, but got %#v", lines)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	const filename = "ringlogger_archive_test.bin"
+	defer os.Remove(filename)
+	dir := t.TempDir()
+	rl, err := NewRinglogger(filename, "ARC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(rl, "line %d", i)
+	}
+	archiver, err := StartArchiver(rl, dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver.Close()
+	lines, err := TailArchive(dir, time.Now(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 5 || lines[0].Message != "line 5" || lines[4].Line != "[ARC] line 9" {
+		t.Errorf("Unexpected archived lines: %+v", lines)
+	}
+
+	archiver, err = StartArchiver(rl, dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver.Close()
+	count := 0
+	err = ReadArchive(dir, time.Time{}, func(line *FollowLine) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Errorf("Expected lines to be archived once, but got %d lines", count)
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	const filename = "ringlogger_syslog_test.bin"
+	defer os.Remove(filename)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rl, err := NewRinglogger(filename, "SYS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	fmt.Fprintf(rl, "old line")
+	forwarder, err := StartSyslogForwarder(rl, "udp://"+conn.LocalAddr().String(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forwarder.Close()
+	rl.WriteRecord(LevelWarning, "office", []byte("new line"), time.Now().UnixNano())
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, syslogMaxDatagram)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(buf[:n])
+	if !strings.HasPrefix(message, "<28>1 ") || !strings.HasSuffix(message, ` AmneziaWG - SYS [amneziawg@32473 tunnel="office"] new line`) {
+		t.Errorf("Unexpected message %#q", message)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	const key = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	line := "peer " + key + " endpoint 192.0.2.1:51820, [2001:db8::1]:51820, vpn.example.com and 192.0.2.1 again, via 127.0.0.1 from log.bin"
+	tests := []struct {
+		level    RedactionLevel
+		expected string
+	}{
+		{RedactNone, line},
+		{RedactKeys, "peer <key-1> endpoint 192.0.2.1:51820, [2001:db8::1]:51820, vpn.example.com and 192.0.2.1 again, via 127.0.0.1 from log.bin"},
+		{RedactAddresses, "peer <key-1> endpoint <ipv4-1>:51820, [<ipv6-1>]:51820, <host-1> and <ipv4-1> again, via 127.0.0.1 from log.bin"},
+	}
+	for _, test := range tests {
+		if actual := NewRedactor(test.level).Redact(line); actual != test.expected {
+			t.Errorf("Redacting at level %v: expected %#q, but got %#q", test.level, test.expected, actual)
+		}
+	}
+}
+
+func TestMissed(t *testing.T) {
+	const filename = "ringlogger_missed_test.bin"
+	defer os.Remove(filename)
+	geometry := Geometry{Lines: MinLines, LineLength: MinLineLength}
+	rl, err := NewRingloggerWithGeometry(filename, "MIS", &geometry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	_, cursor, missed := rl.FollowFromCursor(CursorAll)
+	if missed != 0 {
+		t.Errorf("Expected nothing missed in an empty ring, but got %d", missed)
+	}
+	for i := 0; i < MinLines+72; i++ {
+		fmt.Fprintf(rl, "line %d", i)
+	}
+	lines, cursor, missed := rl.FollowFromCursor(cursor)
+	if missed != 72 || len(lines) != MinLines || lines[0].Message != "line 72" {
+		t.Errorf("Expected 72 missed and %d lines, but got %d missed and %d lines", MinLines, missed, len(lines))
+	}
+	lines, _, missed = rl.FollowFromCursor(cursor)
+	if missed != 0 || len(lines) != 0 {
+		t.Errorf("Expected nothing new, but got %d missed and %d lines", missed, len(lines))
+	}
+}
+
+func TestLongMessage(t *testing.T) {
+	const filename = "ringlogger_long_test.bin"
+	defer os.Remove(filename)
+	geometry := Geometry{Lines: MinLines, LineLength: MinLineLength}
+	rl, err := NewRingloggerWithGeometry(filename, "LNG", &geometry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	message := strings.Repeat("first line ", 30) + "\n" + strings.TrimSpace(strings.Repeat("second line ", 30))
+	fmt.Fprintf(rl, "short")
+	fmt.Fprint(rl, message)
+	fmt.Fprintf(rl, "after")
+	lines, cursor, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 3 || lines[1].Message != message || lines[2].Message != "after" {
+		t.Fatalf("Expected message to be reassembled, but got %d lines", len(lines))
+	}
+	for i := 0; i < MinLines-2; i++ {
+		fmt.Fprintf(rl, "line %d", i)
+	}
+	lines, _, missed := rl.FollowFromCursor(cursor)
+	if missed != 0 || len(lines) != MinLines-2 {
+		t.Errorf("Expected %d lines and nothing missed, but got %d lines and %d missed", MinLines-2, len(lines), missed)
+	}
+	lines, _, _ = rl.FollowFromCursor(CursorAll)
+	if lines[0].Message != "after" {
+		t.Errorf("Expected the partially overwritten message to be skipped, but got %#q", lines[0].Message)
+	}
+}
@@ -0,0 +1,87 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// Each process capturing crashes keeps a pending crash file open, named after
+// its tag and process ID, to which the runtime writes when the process
+// crashes. Once the process has exited, collecting crashes removes the file if
+// it is empty, or otherwise renames it after the time of the crash and the tag.
+const (
+	crashPrefix         = "crash-"
+	crashPendingSuffix  = ".pending"
+	crashSuffix         = ".txt"
+	crashNameTimeLayout = "20060102-150405"
+)
+
+// crashLine is written to the global log once it is crashing.
+var crashLine []byte
+
+// CaptureCrashes has the runtime write the full goroutine dump of a fatal
+// panic or error of this process to a crash file in dir, and, once the global
+// logger sees the crash, record a line pointing to that file in place of the
+// dump.
+func CaptureCrashes(dir, tag string) error {
+	name := fmt.Sprintf("%s%s-%d%s", crashPrefix, tag, os.Getpid(), crashPendingSuffix)
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	crashLine = []byte(fmt.Sprintf("Crashed, so writing the full goroutine dump to %s", name))
+	debug.SetTraceback("all")
+	return debug.SetCrashOutput(file, debug.CrashOptions{})
+}
+
+// CollectCrashes names the crash files in dir of processes that have exited
+// after the time of the crash, removes those that are empty, and returns the
+// names of the others.
+func CollectCrashes(dir string) (crashes []string) {
+	paths, _ := filepath.Glob(filepath.Join(dir, crashPrefix+"*"+crashPendingSuffix))
+	for _, path := range paths {
+		path16, err := windows.UTF16PtrFromString(path)
+		if err != nil {
+			continue
+		}
+		// The crash file of a running process cannot be opened exclusively.
+		handle, err := windows.CreateFile(path16, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+		if err != nil {
+			continue
+		}
+		var info windows.ByHandleFileInformation
+		err = windows.GetFileInformationByHandle(handle, &info)
+		windows.CloseHandle(handle)
+		if err != nil {
+			continue
+		}
+		if info.FileSizeHigh == 0 && info.FileSizeLow == 0 {
+			os.Remove(path)
+			continue
+		}
+		tag, _, _ := strings.Cut(strings.TrimPrefix(filepath.Base(path), crashPrefix), "-")
+		stamp := time.Unix(0, info.LastWriteTime.Nanoseconds())
+		name := fmt.Sprintf("%s%s-%s%s", crashPrefix, stamp.Format(crashNameTimeLayout), tag, crashSuffix)
+		err = os.Rename(path, filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("Unable to keep crash file %s: %v", path, err)
+			continue
+		}
+		log.Printf("The %s process crashed at %v; its goroutine dump is in %s", tag, stamp.Format(time.DateTime), name)
+		crashes = append(crashes, name)
+	}
+	return
+}
@@ -7,50 +7,63 @@ package ringlogger
 
 import (
 	"errors"
-	"fmt"
 	"io"
-	"os"
 	"time"
-
-	"golang.org/x/sys/windows"
 )
 
-func DumpTo(inPath string, out io.Writer, continuous bool) error {
-	file, err := os.Open(inPath)
-	if err != nil {
-		return err
+type DumpOptions struct {
+	Continuous bool // Keep following new lines, unless the ring is a copy of a file.
+	JSON       bool
+	Filter     Filter
+	ArchiveDir string // If set, archived lines older than the ring are dumped first.
+	Redaction  RedactionLevel
+}
+
+// Dump writes the lines of rl that match the filter of options to out.
+func Dump(rl *Ringlogger, out io.Writer, options *DumpOptions) error {
+	var err error
+	writeLine := (*FollowLine).WriteText
+	if options.JSON {
+		writeLine = (*FollowLine).WriteJSON
 	}
-	defer file.Close()
-	mapping, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READONLY, 0, 0, nil)
-	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
-		return err
+	redactor := NewRedactor(options.Redaction)
+	dump := func(line *FollowLine) bool {
+		if options.Filter.Match(line) {
+			redacted := redactor.RedactLine(*line)
+			_, err = writeLine(&redacted, out)
+		}
+		return err == nil
 	}
-	rl, err := newRingloggerFromMappingHandle(mapping, "DMP", windows.FILE_MAP_READ)
-	if err != nil {
-		windows.CloseHandle(mapping)
-		return err
+	items, cursor, _ := rl.FollowFromCursor(CursorAll)
+	if len(options.ArchiveDir) > 0 {
+		oldest := time.Now()
+		if len(items) > 0 {
+			oldest = items[0].Stamp
+		}
+		archiveErr := ReadArchive(options.ArchiveDir, options.Filter.Since, func(line *FollowLine) bool {
+			return !line.Stamp.Before(oldest) || dump(line)
+		})
+		if err == nil && archiveErr != nil {
+			return archiveErr
+		}
 	}
-	defer rl.Close()
-	if !continuous {
-		_, err = rl.WriteTo(out)
-		if err != nil {
-			return err
+	var missed uint64
+	for err == nil {
+		if missed > 0 {
+			missedLine := MissedLine(missed)
+			_, err = writeLine(&missedLine, out)
 		}
-	} else {
-		cursor := CursorAll
-		for {
-			var items []FollowLine
-			items, cursor = rl.FollowFromCursor(cursor)
-			for _, item := range items {
-				_, err = fmt.Fprintf(out, "%s: %s\n", item.Stamp.Format("2006-01-02 15:04:05.000000"), item.Line)
-				if errors.Is(err, io.EOF) {
-					return nil
-				} else if err != nil {
-					return err
-				}
-			}
-			time.Sleep(time.Millisecond * 100)
+		for i := 0; err == nil && i < len(items); i++ {
+			dump(&items[i])
 		}
+		if err != nil || !options.Continuous || rl.frozen || (!options.Filter.Until.IsZero() && time.Now().After(options.Filter.Until)) {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+		items, cursor, missed = rl.FollowFromCursor(cursor)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
 	}
-	return nil
+	return err
 }
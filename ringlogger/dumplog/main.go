@@ -0,0 +1,82 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+// Command dumplog dumps a manager-log.bin or log.bin file on any system,
+// filtered in the same way as by the /dumplog command line option of the
+// client.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
+)
+
+func main() {
+	var (
+		options                          ringlogger.DumpOptions
+		since, until, tags, level, regex string
+		redaction                        string
+	)
+	flag.BoolVar(&options.JSON, "json", false, "write lines as JSON objects")
+	flag.StringVar(&since, "since", "", "skip lines before `TIME`, which is absolute or a duration before now")
+	flag.StringVar(&until, "until", "", "skip lines after `TIME`")
+	flag.StringVar(&tags, "tag", "", "only dump lines of the comma separated `TAGS`")
+	flag.StringVar(&options.Filter.Tunnel, "tunnel", "", "only dump lines of the tunnel `NAME`")
+	flag.StringVar(&level, "level", "", "skip lines less severe than `LEVEL`")
+	flag.StringVar(&options.Filter.Contains, "match", "", "only dump lines containing `TEXT`")
+	flag.StringVar(&regex, "regex", "", "only dump lines matching `PATTERN`")
+	flag.StringVar(&redaction, "redact", "none", "mask keys or addresses, by `LEVEL` none, keys or addresses")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [OPTIONS] LOG_BIN\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	now := time.Now()
+	if len(since) > 0 {
+		options.Filter.Since, err = ringlogger.ParseTime(since, now)
+		check(err)
+	}
+	if len(until) > 0 {
+		options.Filter.Until, err = ringlogger.ParseTime(until, now)
+		check(err)
+	}
+	if len(tags) > 0 {
+		options.Filter.Tags = strings.Split(tags, ",")
+	}
+	if len(level) > 0 {
+		options.Filter.MaxLevel, err = ringlogger.ParseLevel(level)
+		check(err)
+	}
+	if len(regex) > 0 {
+		options.Filter.Regexp, err = regexp.Compile(regex)
+		check(err)
+	}
+	options.Redaction, err = ringlogger.ParseRedactionLevel(redaction)
+	check(err)
+
+	rl, err := ringlogger.ReadRingloggerFile(flag.Arg(0))
+	check(err)
+	defer rl.Close()
+	check(ringlogger.Dump(rl, os.Stdout, &options))
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,108 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Filter selects lines by their fields. The zero value matches everything.
+type Filter struct {
+	Since    time.Time
+	Until    time.Time
+	Tags     []string
+	Tunnel   string
+	MaxLevel Level
+	Contains string
+	Regexp   *regexp.Regexp
+}
+
+func (f *Filter) Match(line *FollowLine) bool {
+	if !f.Since.IsZero() && line.Stamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && line.Stamp.After(f.Until) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		found := false
+		for _, tag := range f.Tags {
+			if strings.EqualFold(tag, line.Tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Tunnel) > 0 && !strings.EqualFold(f.Tunnel, line.Tunnel) {
+		return false
+	}
+	if f.MaxLevel != 0 && line.Level > f.MaxLevel {
+		return false
+	}
+	if len(f.Contains) > 0 && !strings.Contains(line.Line, f.Contains) {
+		return false
+	}
+	if f.Regexp != nil && !f.Regexp.MatchString(line.Line) {
+		return false
+	}
+	return true
+}
+
+var timeLayouts = [...]string{
+	"2006-01-02 15:04:05.000000",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses an absolute time, in RFC 3339 or in the local time zone
+// using one of the layouts of the text output, or a duration such as "90m",
+// which is taken to be relative to now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %#q", s)
+}
+
+type jsonLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	Level     string    `json:"level"`
+	Tag       string    `json:"tag"`
+	Tunnel    string    `json:"tunnel,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// WriteJSON writes the line as a single JSON object followed by a newline.
+func (line *FollowLine) WriteJSON(out io.Writer) (int, error) {
+	b, err := json.Marshal(&jsonLine{line.Stamp, line.Level.String(), line.Tag, line.Tunnel, line.Message})
+	if err != nil {
+		return 0, err
+	}
+	return out.Write(append(b, '\n'))
+}
+
+// WriteText writes the line in the same format as Ringlogger.WriteTo.
+func (line *FollowLine) WriteText(out io.Writer) (int, error) {
+	return fmt.Fprintf(out, "%s: %s\n", line.Stamp.Format("2006-01-02 15:04:05.000000"), line.Line)
+}
@@ -0,0 +1,330 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A log file starts with a header describing its geometry, followed by the
+// ring of fixed size records:
+//
+//	offset  size  field
+//	0       4     magic
+//	4       4     unused, formerly the index of the next record
+//	8       4     format version
+//	12      4     header size, which is the offset of the first record
+//	16      4     number of records
+//	20      4     maximum line length, including the terminating NUL
+//	24      8     sequence number of the last record reserved by a writer
+//
+// Records are numbered from 1, and record n is stored in slot (n - 1) modulo
+// the number of records. Each record is made of the following fields, padded
+// to a multiple of 8:
+//
+//	0       8     sequence number, set before writing the other fields
+//	8       8     sequence number, set after writing the other fields
+//	16      8     timestamp in nanoseconds
+//	24      4     level in the low 16 bits, and flags in the high 16 bits
+//	28      5     tag
+//	33      32    tunnel name
+//	65      n     line, where n is the maximum line length
+//
+// Messages longer than a line are split across consecutive records, all of
+// which but the last have the recordContinues flag, and all of which but the
+// first have the recordContinuation flag. Each of them carries the time, level,
+// tag and tunnel name of the message.
+//
+// Records are written and read a word at a time with atomic operations, and a
+// reader takes a copy to be consistent only if both sequence numbers match the
+// one it expects, which tells apart records that are still being written from
+// those that have since been overwritten.
+//
+// All integers are little endian. Earlier formats can still be read: version
+// 1, whose records lack the sequence numbers, and whose header instead holds a
+// 32-bit count of records at offset 4; headerless files identified by
+// headerlessMagic, which store records of version 1 with the default geometry
+// immediately after that count; and legacy files identified by legacyMagic,
+// which store only a timestamp and a line of text of the form "[TAG] message".
+// Readers of those formats see records as complete once their timestamp is set.
+const (
+	magic           = 0xbadbac0
+	headerlessMagic = 0xbadbabf
+	legacyMagic     = 0xbadbabe
+	formatVersion   = 2
+
+	headerSize = 64
+
+	offsetMagic        = 0
+	offsetNextIndex    = 4
+	offsetVersion      = 8
+	offsetHeaderSize   = 12
+	offsetLines        = 16
+	offsetLineLength   = 20
+	offsetLastSequence = 24
+
+	recordOffsetBegin = 0
+	recordOffsetEnd   = 8
+	recordBase        = 16
+
+	// These are relative to the base of the record, which is zero before version 2.
+	recordOffsetTime   = 0
+	recordOffsetLevel  = 8
+	recordOffsetTag    = 12
+	recordOffsetTunnel = 17
+	recordOffsetLine   = 49
+
+	legacyRecordOffsetLine = 8
+
+	recordContinues    = 1 << 0
+	recordContinuation = 1 << 1
+
+	maxMessageRecords = 64
+
+	maxTagLength        = 5
+	maxTunnelNameLength = 32
+
+	defaultLines      = 2048
+	defaultLineLength = 512
+
+	MinLines      = 128
+	MaxLines      = 1 << 18
+	MinLineLength = 128
+	MaxLineLength = 8192
+	maxFileSize   = 256 << 20
+)
+
+// Geometry describes the size of the ring of a log file.
+type Geometry struct {
+	Lines      uint32 // Number of records in the ring, a power of two.
+	LineLength uint32 // Maximum length of a line, including the terminating NUL.
+}
+
+var DefaultGeometry = Geometry{Lines: defaultLines, LineLength: defaultLineLength}
+
+var errInvalidGeometry = errors.New("Invalid log geometry")
+
+func (g Geometry) Valid() bool {
+	return g.Lines >= MinLines && g.Lines <= MaxLines && g.Lines&(g.Lines-1) == 0 &&
+		g.LineLength >= MinLineLength && g.LineLength <= MaxLineLength &&
+		headerSize+int64(g.Lines)*int64(g.recordSize(recordBase)) <= maxFileSize
+}
+
+func (g Geometry) String() string {
+	return fmt.Sprintf("%d lines of %d bytes", g.Lines, g.LineLength)
+}
+
+func (g Geometry) recordSize(base uint32) uint32 {
+	return (base + recordOffsetLine + g.LineLength + 7) &^ 7
+}
+
+// layout describes where the fields of an opened log file are found.
+type layout struct {
+	Geometry
+	magic      uint32
+	version    uint32
+	headerSize uint32
+	recordSize uint32
+	base       uint32
+}
+
+func newLayout(g Geometry) layout {
+	return layout{Geometry: g, magic: magic, version: formatVersion, headerSize: headerSize, recordSize: g.recordSize(recordBase), base: recordBase}
+}
+
+// writable returns whether the file is of the current format, which is the
+// only one that is written.
+func (l *layout) writable() bool {
+	return l.magic == magic && l.version == formatVersion
+}
+
+// sequenced returns whether records carry their sequence numbers.
+func (l *layout) sequenced() bool {
+	return l.magic == magic && l.version >= 2
+}
+
+func (l *layout) legacy() bool {
+	return l.magic == legacyMagic
+}
+
+func (l *layout) fileSize() int64 {
+	return int64(l.headerSize) + int64(l.Lines)*int64(l.recordSize)
+}
+
+// recordOffset returns the offset of record number sequence.
+func (l *layout) recordOffset(sequence uint64) int64 {
+	return int64(l.headerSize) + int64((sequence-1)%uint64(l.Lines))*int64(l.recordSize)
+}
+
+// parseLayout determines the layout of a log file of the given size from its
+// first bytes, which must be at least headerSize long if available.
+func parseLayout(header []byte, size int64) (l layout, err error) {
+	if len(header) < 8 {
+		return l, errInvalidGeometry
+	}
+	switch binary.LittleEndian.Uint32(header[offsetMagic:]) {
+	case magic:
+		if len(header) < headerSize {
+			return l, errInvalidGeometry
+		}
+		l = layout{
+			Geometry: Geometry{
+				Lines:      binary.LittleEndian.Uint32(header[offsetLines:]),
+				LineLength: binary.LittleEndian.Uint32(header[offsetLineLength:]),
+			},
+			magic:      magic,
+			version:    binary.LittleEndian.Uint32(header[offsetVersion:]),
+			headerSize: binary.LittleEndian.Uint32(header[offsetHeaderSize:]),
+		}
+		switch l.version {
+		case 1:
+			l.base = 0
+		case 2:
+			l.base = recordBase
+		default:
+			return l, fmt.Errorf("Unsupported log format version %d", l.version)
+		}
+		if !l.Valid() || l.headerSize < headerSize || l.headerSize%8 != 0 {
+			return l, errInvalidGeometry
+		}
+		l.recordSize = l.Geometry.recordSize(l.base)
+	case headerlessMagic:
+		l = layout{Geometry: DefaultGeometry, magic: headerlessMagic, version: 1, headerSize: 8, recordSize: DefaultGeometry.recordSize(0)}
+	case legacyMagic:
+		l = layout{Geometry: DefaultGeometry, magic: legacyMagic, headerSize: 8, recordSize: legacyRecordOffsetLine + defaultLineLength}
+	default:
+		return l, errInvalidGeometry
+	}
+	if size < l.fileSize() {
+		return l, errInvalidGeometry
+	}
+	return l, nil
+}
+
+// putHeader fills in the header of a new file, except for its magic, which is
+// to be written last.
+func (l *layout) putHeader(header []byte) {
+	binary.LittleEndian.PutUint32(header[offsetVersion:], l.version)
+	binary.LittleEndian.PutUint32(header[offsetHeaderSize:], l.headerSize)
+	binary.LittleEndian.PutUint32(header[offsetLines:], l.Lines)
+	binary.LittleEndian.PutUint32(header[offsetLineLength:], l.LineLength)
+}
+
+// messageRecords returns how many records a message of the given length takes.
+func (l *layout) messageRecords(length int) int {
+	chunk := int(l.LineLength) - 1
+	return max(1, min((length+chunk-1)/chunk, maxMessageRecords, int(l.Lines)/16))
+}
+
+// encodeMessagePart fills in a copy of record i of the n records holding a
+// message, except for its sequence numbers, splitting and truncating the
+// message to fit them.
+func (l *layout) encodeMessagePart(record []byte, i, n int, level Level, tag, tunnel string, message []byte, ts int64) {
+	chunk := int(l.LineLength) - 1
+	var flags uint16
+	if i > 0 {
+		flags |= recordContinuation
+	}
+	if i < n-1 {
+		flags |= recordContinues
+	}
+	part := message[min(i*chunk, len(message)):min((i+1)*chunk, len(message))]
+	l.encodeRecord(record, level, flags, tag, tunnel, part, ts)
+}
+
+// encodeRecord fills in a copy of a record, except for its sequence numbers.
+func (l *layout) encodeRecord(record []byte, level Level, flags uint16, tag, tunnel string, message []byte, ts int64) {
+	for i := range record {
+		record[i] = 0
+	}
+	fields := record[l.base:]
+	binary.LittleEndian.PutUint64(fields[recordOffsetTime:], uint64(ts))
+	binary.LittleEndian.PutUint32(fields[recordOffsetLevel:], uint32(level)&0xffff|uint32(flags)<<16)
+	copy(fields[recordOffsetTag:recordOffsetTag+maxTagLength], tag)
+	copy(fields[recordOffsetTunnel:recordOffsetTunnel+maxTunnelNameLength], tunnel)
+	copy(fields[recordOffsetLine:recordOffsetLine+l.LineLength-1], message)
+}
+
+func cString(b []byte) string {
+	if index := bytes.IndexByte(b, 0); index >= 0 {
+		b = b[:index]
+	}
+	return string(b)
+}
+
+func formatLine(tag, tunnel, message string) string {
+	if len(tunnel) > 0 {
+		return fmt.Sprintf("[%s] [%s] %s", tag, tunnel, message)
+	}
+	return fmt.Sprintf("[%s] %s", tag, message)
+}
+
+// decodeRecord decodes a copy of a record. It returns false if the record has
+// no timestamp, which in formats without sequence numbers means that it has
+// never been written or is being written.
+func (l *layout) decodeRecord(record []byte) (followLine FollowLine, flags uint16, ok bool) {
+	record = record[l.base:]
+	timeNs := int64(binary.LittleEndian.Uint64(record[recordOffsetTime:]))
+	if timeNs == 0 {
+		return
+	}
+	if l.legacy() {
+		return decodeLegacyRecord(timeNs, record[legacyRecordOffsetLine:]), 0, true
+	}
+	levelAndFlags := binary.LittleEndian.Uint32(record[recordOffsetLevel:])
+	flags = uint16(levelAndFlags >> 16)
+	followLine = FollowLine{
+		Stamp:   time.Unix(0, timeNs),
+		Level:   Level(levelAndFlags & 0xffff),
+		Tag:     cString(record[recordOffsetTag : recordOffsetTag+maxTagLength]),
+		Tunnel:  cString(record[recordOffsetTunnel : recordOffsetTunnel+maxTunnelNameLength]),
+		Message: cString(record[recordOffsetLine : recordOffsetLine+l.LineLength]),
+	}
+	if len(followLine.Message) > 0 {
+		followLine.Line = formatLine(followLine.Tag, followLine.Tunnel, followLine.Message)
+	}
+	return followLine, flags, true
+}
+
+func decodeLegacyRecord(timeNs int64, line []byte) (followLine FollowLine) {
+	followLine = FollowLine{Stamp: time.Unix(0, timeNs), Level: DefaultLevel}
+	index := bytes.IndexByte(line, 0)
+	if index < 1 {
+		return followLine
+	}
+	followLine.Line = string(line[:index])
+	followLine.Message = followLine.Line
+	if tag, rest, found := strings.Cut(followLine.Line, "] "); found && len(tag) > 1 && tag[0] == '[' {
+		tunnel, message := splitTunnelPrefix([]byte(rest))
+		followLine.Tag, followLine.Tunnel, followLine.Message = tag[1:], tunnel, string(message)
+	}
+	return followLine
+}
+
+func tunnelNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '=' || c == '+' || c == '.' || c == '-'
+}
+
+func splitTunnelPrefix(p []byte) (tunnel string, message []byte) {
+	if len(p) < 4 || p[0] != '[' {
+		return "", p
+	}
+	end := bytes.Index(p, []byte("] "))
+	if end < 2 || end > maxTunnelNameLength+1 {
+		return "", p
+	}
+	for _, c := range p[1:end] {
+		if !tunnelNameChar(c) {
+			return "", p
+		}
+	}
+	return string(p[1:end]), p[end+2:]
+}
@@ -0,0 +1,118 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var Global *Ringlogger
+
+func InitGlobalLogger(file, tag string) error {
+	return InitGlobalLoggerWithGeometry(file, tag, nil)
+}
+
+func InitGlobalLoggerWithGeometry(file, tag string, geometry *Geometry) error {
+	if Global != nil {
+		return nil
+	}
+	var err error
+	Global, err = NewRingloggerWithGeometry(file, tag, geometry)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(Global)
+	log.SetFlags(0)
+	overrideWrite = globalWrite
+	return nil
+}
+
+// Logf writes a line with the given level and tunnel name, which may be
+// empty, to the global logger. Lines written with the log package instead are
+// given DefaultLevel.
+func Logf(level Level, tunnel, format string, v ...any) {
+	if Global == nil {
+		if len(tunnel) > 0 {
+			format = "[" + tunnel + "] " + format
+		}
+		log.Printf(format, v...)
+		return
+	}
+	Global.WriteRecord(level, tunnel, []byte(fmt.Sprintf(format, v...)), time.Now().UnixNano())
+}
+
+//go:linkname overrideWrite runtime.overrideWrite
+var overrideWrite func(fd uintptr, p unsafe.Pointer, n int32) int32
+
+var (
+	globalBuffer         [4096]byte
+	globalBufferLocation int
+	globalRecord         [recordBase + recordOffsetLine + MaxLineLength + 8]byte
+	globalCrashing       bool
+	globalCrashRecorded  bool
+)
+
+// globalWrite receives what the runtime writes. While crashing, the runtime
+// cannot allocate, and writes its output to standard error, and then again to
+// the file set by debug.SetCrashOutput, which is the only other file it writes
+// to. So once that file is written to, only the line under way is recorded,
+// followed by crashLine, while the rest goes to the crash file only.
+//
+//go:nosplit
+func globalWrite(fd uintptr, p unsafe.Pointer, n int32) int32 {
+	b := (*[1 << 30]byte)(p)[:n]
+	if fd != 1 && fd != 2 {
+		globalCrashing = true
+		var written uint32
+		windows.WriteFile(windows.Handle(fd), b, &written, nil)
+		return n
+	}
+	if globalCrashRecorded {
+		return n
+	}
+	for len(b) > 0 {
+		amountAvailable := len(globalBuffer) - globalBufferLocation
+		amountToCopy := len(b)
+		if amountToCopy > amountAvailable {
+			amountToCopy = amountAvailable
+		}
+		copy(globalBuffer[globalBufferLocation:], b[:amountToCopy])
+		b = b[amountToCopy:]
+		globalBufferLocation += amountToCopy
+		foundNl := false
+		for i := globalBufferLocation - amountToCopy; i < globalBufferLocation; i++ {
+			if globalBuffer[i] == '\n' {
+				foundNl = true
+				break
+			}
+		}
+		if foundNl || len(b) > 0 {
+			globalWriteLine(DefaultLevel, globalBuffer[:globalBufferLocation])
+			globalBufferLocation = 0
+			if globalCrashing {
+				globalCrashRecorded = true
+				globalWriteLine(LevelError, crashLine)
+				break
+			}
+		}
+	}
+	return n
+}
+
+// globalWriteLine writes a line to the global logger without allocating.
+func globalWriteLine(level Level, p []byte) {
+	p = bytes.TrimSpace(p)
+	if len(p) == 0 || level > CurrentVerbosity().Level(Global.tag, "") || Global.view == nil || !Global.layout.writable() {
+		return
+	}
+	Global.writeMessageUsing(globalRecord[:Global.layout.recordSize], level, Global.tag, "", p, time.Now().UnixNano())
+}
@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"sync"
+	"time"
+)
+
+// Importer copies the lines that other writers add to a log file of their own,
+// such as the tunnel services writing the legacy format through the tunnel
+// library, into a ring, where they get the tag and tunnel name parsed from
+// their text, and are read, filtered, archived and forwarded along with the
+// lines of the ring. Lines more verbose than the current verbosity of their tag
+// and tunnel are left out, as those writers cannot be told it.
+type Importer struct {
+	rl      *Ringlogger
+	open    func() (*Ringlogger, error)
+	from    *Ringlogger
+	last    time.Time // Stamp of the newest line imported before starting.
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// StartImporter imports the lines of the log opened by open into rl every
+// second, starting with the lines still in that log that are newer than those
+// imported before. As the writers of that log may not have created it yet,
+// open is called again until it succeeds.
+func StartImporter(rl *Ringlogger, open func() (*Ringlogger, error)) *Importer {
+	im := &Importer{rl: rl, open: open, stop: make(chan struct{})}
+	// The ring holds no lines of other tags than its own but those imported.
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	for i := range lines {
+		if lines[i].Tag != rl.tag && lines[i].Stamp.After(im.last) {
+			im.last = lines[i].Stamp
+		}
+	}
+	im.stopped.Add(1)
+	go im.run()
+	return im
+}
+
+func (im *Importer) run() {
+	defer im.stopped.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	cursor := CursorAll
+	stopping := false
+	first := true
+	for {
+		if im.from == nil {
+			im.from, _ = im.open()
+		}
+		if im.from != nil {
+			var lines []FollowLine
+			var missed uint64
+			lines, cursor, missed = im.from.FollowFromCursor(cursor)
+			if missed > 0 {
+				im.importLine(MissedLine(missed))
+			}
+			for i := range lines {
+				// As with archiving, only the lines still in the log when
+				// starting may have been imported before.
+				if first && !lines[i].Stamp.After(im.last) {
+					continue
+				}
+				im.importLine(lines[i])
+			}
+			first = false
+		}
+		if stopping {
+			if im.from != nil {
+				im.from.Close()
+			}
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-im.stop:
+			stopping = true
+		}
+	}
+}
+
+func (im *Importer) importLine(line FollowLine) {
+	if im.rl.readOnly || im.rl.view == nil || !im.rl.layout.writable() || len(line.Message) == 0 {
+		return
+	}
+	if line.Level > CurrentVerbosity().Level(line.Tag, line.Tunnel) {
+		return
+	}
+	im.rl.writeMessage(line.Level, line.Tag, line.Tunnel, []byte(line.Message), line.Stamp.UnixNano())
+}
+
+// Close imports what is left in the log and stops the importer.
+func (im *Importer) Close() error {
+	if im.stop != nil {
+		close(im.stop)
+		im.stopped.Wait()
+		im.stop = nil
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// legacyRing is a ring of the legacy format kept in memory, as the tunnel
+// library writes it.
+type legacyRing struct {
+	layout layout
+	words  []uint64
+}
+
+func newLegacyRing() *legacyRing {
+	l := layout{Geometry: DefaultGeometry, magic: legacyMagic, headerSize: 8, recordSize: legacyRecordOffsetLine + defaultLineLength}
+	r := &legacyRing{layout: l, words: make([]uint64, l.fileSize()/8)}
+	binary.LittleEndian.PutUint32(r.bytes()[offsetMagic:], legacyMagic)
+	return r
+}
+
+func (r *legacyRing) bytes() []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(&r.words[0])), r.layout.fileSize())
+}
+
+func (r *legacyRing) write(line string, stamp time.Time) {
+	b := r.bytes()
+	next := binary.LittleEndian.Uint32(b[offsetNextIndex:]) + 1
+	offset := r.layout.recordOffset(uint64(next))
+	binary.LittleEndian.PutUint64(b[offset:], uint64(stamp.UnixNano()))
+	copy(b[offset+legacyRecordOffsetLine:], line+"\x00")
+	binary.LittleEndian.PutUint32(b[offsetNextIndex:], next)
+}
+
+func (r *legacyRing) open() (*Ringlogger, error) {
+	return &Ringlogger{tag: "IMP", view: unsafe.Pointer(&r.words[0]), layout: r.layout, readOnly: true}, nil
+}
+
+func TestImporter(t *testing.T) {
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "MGR")
+	legacy := newLegacyRing()
+	stamp := time.Now().Add(-time.Minute)
+	legacy.write("[TUN] [office] Interface up", stamp)
+	legacy.write("[TUN] Shutting down", stamp.Add(time.Second))
+	rl.WriteWithTimestamp([]byte("Starting"), stamp.Add(2*time.Second).UnixNano())
+
+	StartImporter(rl, legacy.open).Close()
+	legacy.write("[TUN] [office] Interface down", stamp.Add(3*time.Second))
+	// Restarting must not import lines again, even though lines of the ring
+	// itself are newer.
+	StartImporter(rl, legacy.open).Close()
+
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	var messages []string
+	for _, line := range lines {
+		messages = append(messages, line.Line)
+	}
+	expected := []string{"[MGR] Starting", "[TUN] [office] Interface up", "[TUN] Shutting down", "[TUN] [office] Interface down"}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %q, but got %q", expected, messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Fatalf("Expected %q, but got %q", expected, messages)
+		}
+	}
+	if lines[1].Tag != "TUN" || lines[1].Tunnel != "office" || lines[1].Level != DefaultLevel || !lines[1].Stamp.Equal(stamp) {
+		t.Errorf("Imported line lost its fields: %+v", lines[1])
+	}
+}
+
+func TestImporterVerbosity(t *testing.T) {
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "MGR")
+	legacy := newLegacyRing()
+	stamp := time.Now()
+	legacy.write("[TUN] [office] Handshake did not complete", stamp)
+	legacy.write("[TUN] [home] Handshake did not complete", stamp)
+	SetVerbosity(CurrentVerbosity().With("", "office", LevelWarning))
+	t.Cleanup(func() { SetVerbosity(nil) })
+
+	StartImporter(rl, legacy.open).Close()
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 1 || lines[0].Tunnel != "home" {
+		t.Errorf("Expected only the line of the tunnel at the default verbosity, but got %+v", lines)
+	}
+}
@@ -0,0 +1,53 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Level uint32
+
+const (
+	LevelError Level = iota + 1
+	LevelWarning
+	LevelInfo
+	LevelVerbose
+)
+
+// DefaultLevel is given to lines written through the io.Writer interface, which
+// includes everything written using the log package.
+const DefaultLevel = LevelInfo
+
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelWarning:
+		return "warning"
+	case LevelInfo:
+		return "info"
+	case LevelVerbose:
+		return "verbose"
+	default:
+		return fmt.Sprintf("level%d", uint32(l))
+	}
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "error":
+		return LevelError, nil
+	case "warning":
+		return LevelWarning, nil
+	case "info":
+		return LevelInfo, nil
+	case "verbose":
+		return LevelVerbose, nil
+	}
+	return 0, fmt.Errorf("Invalid log level %#q", s)
+}
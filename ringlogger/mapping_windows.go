@@ -0,0 +1,256 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func NewRinglogger(filename, tag string) (*Ringlogger, error) {
+	return NewRingloggerWithGeometry(filename, tag, nil)
+}
+
+// NewRingloggerWithGeometry opens filename for writing, creating it with the
+// given geometry if it does not exist. If geometry is nil, an existing file
+// keeps its own geometry, and new files get DefaultGeometry. Otherwise, an
+// existing file of a different geometry is converted by copying its lines into
+// a new file, which then replaces it. As the replacement fails while other
+// processes have the file open, writers never disagree on the geometry of a
+// file; instead, the conversion is deferred until the file is next opened.
+// Files of earlier formats are converted likewise, but since they cannot be
+// written, failing to replace them is an error.
+func NewRingloggerWithGeometry(filename, tag string, geometry *Geometry) (*Ringlogger, error) {
+	if len(tag) > maxTagLength {
+		return nil, windows.ERROR_LABEL_TOO_LONG
+	}
+	want := DefaultGeometry
+	if geometry != nil {
+		want = *geometry
+	}
+	if !want.Valid() {
+		return nil, errInvalidGeometry
+	}
+	rl, err := openRinglogger(filename, tag, want, false)
+	if err != nil {
+		return nil, err
+	}
+	if rl.layout.writable() && (geometry == nil || rl.layout.Geometry == want) {
+		return rl, nil
+	}
+
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	from := rl.layout
+	replaceErr := replaceRinglogger(filename, tag, want, lines, rl)
+	rl, err = openRinglogger(filename, tag, want, false)
+	if err != nil {
+		return nil, err
+	}
+	if replaceErr == nil && rl.layout.writable() && rl.layout.Geometry == want {
+		return rl, nil
+	}
+	if !rl.layout.writable() {
+		// Converting in place would have the old writers that still have the
+		// file open corrupt it.
+		rl.Close()
+		if replaceErr == nil {
+			replaceErr = windows.ERROR_SHARING_VIOLATION
+		}
+		return nil, fmt.Errorf("Unable to convert log file of an earlier format: %w", replaceErr)
+	}
+	rl.WriteRecord(LevelWarning, "", []byte(fmt.Sprintf("Log file is in use, so keeping %v rather than %v until next start", from.Geometry, want)), time.Now().UnixNano())
+	return rl, nil
+}
+
+// replaceRinglogger writes lines to a new file of the given geometry, closes
+// rl, and replaces filename with the new file.
+func replaceRinglogger(filename, tag string, geometry Geometry, lines []FollowLine, rl *Ringlogger) error {
+	tempName := filename + ".new"
+	os.Remove(tempName)
+	next, err := openRinglogger(tempName, tag, geometry, true)
+	if err != nil {
+		rl.Close()
+		return err
+	}
+	next.importLines(lines)
+	next.Close()
+	rl.Close()
+	from, err := windows.UTF16PtrFromString(tempName)
+	if err != nil {
+		return err
+	}
+	to, err := windows.UTF16PtrFromString(filename)
+	if err != nil {
+		return err
+	}
+	err = windows.MoveFileEx(from, to, windows.MOVEFILE_REPLACE_EXISTING|windows.MOVEFILE_WRITE_THROUGH)
+	if err != nil {
+		os.Remove(tempName)
+	}
+	return err
+}
+
+// openRinglogger opens filename for writing in whatever format it has, unless
+// it is not a valid log file or reset is true, in which case it is formatted
+// with the given geometry.
+func openRinglogger(filename, tag string, geometry Geometry, reset bool) (*Ringlogger, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	var l layout
+	if !reset {
+		l, err = readLayout(file)
+	}
+	if reset || err != nil {
+		l = newLayout(geometry)
+		reset = true
+		err = file.Truncate(l.fileSize())
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	mapping, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READWRITE, 0, 0, nil)
+	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+		file.Close()
+		return nil, err
+	}
+	rl, err := newRingloggerFromMappingHandle(mapping, tag, windows.FILE_MAP_WRITE, &l)
+	if err != nil {
+		windows.CloseHandle(mapping)
+		file.Close()
+		return nil, err
+	}
+	rl.file = file
+	if reset {
+		rl.format()
+	}
+	return rl, nil
+}
+
+func readLayout(file *os.File) (layout, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return layout{}, err
+	}
+	header := make([]byte, headerSize)
+	n, _ := file.ReadAt(header, 0)
+	return parseLayout(header[:n], info.Size())
+}
+
+func NewRingloggerFromInheritedMappingHandle(handleStr, tag string) (*Ringlogger, error) {
+	handle, err := strconv.ParseUint(handleStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return newRingloggerFromMappingHandle(windows.Handle(handle), tag, windows.FILE_MAP_READ, nil)
+}
+
+// newRingloggerFromMappingHandle maps the file, whose layout is read from the
+// mapping unless given.
+func newRingloggerFromMappingHandle(mappingHandle windows.Handle, tag string, access uint32, l *layout) (*Ringlogger, error) {
+	view, err := windows.MapViewOfFile(mappingHandle, access, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	var info windows.MemoryBasicInformation
+	err = windows.VirtualQuery(view, &info, unsafe.Sizeof(info))
+	if err != nil {
+		windows.UnmapViewOfFile(view)
+		return nil, err
+	}
+	rl := &Ringlogger{
+		tag:  tag,
+		view: unsafe.Pointer(view),
+		unmap: func() {
+			windows.UnmapViewOfFile(view)
+			windows.CloseHandle(mappingHandle)
+		},
+		readOnly: access&windows.FILE_MAP_WRITE == 0,
+	}
+	if l != nil {
+		rl.layout = *l
+	} else {
+		header := unsafe.Slice((*byte)(rl.view), min(info.RegionSize, headerSize))
+		rl.layout, err = parseLayout(header, int64(info.RegionSize))
+		if err != nil {
+			windows.UnmapViewOfFile(view)
+			return nil, windows.ERROR_FILE_CORRUPT
+		}
+	}
+	if uintptr(rl.layout.fileSize()) > info.RegionSize {
+		windows.UnmapViewOfFile(view)
+		return nil, windows.ERROR_FILE_CORRUPT
+	}
+	runtime.SetFinalizer(rl, (*Ringlogger).Close)
+	return rl, nil
+}
+
+// format clears the file and writes its header, with the magic written last,
+// so that readers do not see a partial header.
+func (rl *Ringlogger) format() {
+	size := uintptr(rl.layout.fileSize())
+	b := unsafe.Slice((*byte)(rl.view), size)
+	for i := range b {
+		b[i] = 0
+	}
+	rl.layout.putHeader(b)
+	atomic.StoreUint32((*uint32)(unsafe.Add(rl.view, offsetMagic)), rl.layout.magic)
+	windows.FlushViewOfFile(uintptr(rl.view), size)
+}
+
+// NewRingloggerForReading opens filename for reading, in whatever format it
+// has, while other processes may be writing to it.
+func NewRingloggerForReading(filename, tag string) (*Ringlogger, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	mapping, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READONLY, 0, 0, nil)
+	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+		return nil, err
+	}
+	rl, err := newRingloggerFromMappingHandle(mapping, tag, windows.FILE_MAP_READ, nil)
+	if err != nil {
+		windows.CloseHandle(mapping)
+		return nil, err
+	}
+	return rl, nil
+}
+
+// DumpTo dumps the log file at inPath, which may be written to concurrently.
+func DumpTo(inPath string, out io.Writer, options *DumpOptions) error {
+	rl, err := NewRingloggerForReading(inPath, "DMP")
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+	return Dump(rl, out, options)
+}
+
+func (rl *Ringlogger) ExportInheritableMappingHandle() (handleToClose windows.Handle, err error) {
+	handleToClose, err = windows.CreateFileMapping(windows.Handle(rl.file.Fd()), nil, windows.PAGE_READONLY, 0, 0, nil)
+	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+		return
+	}
+	err = windows.SetHandleInformation(handleToClose, windows.HANDLE_FLAG_INHERIT, windows.HANDLE_FLAG_INHERIT)
+	if err != nil {
+		windows.CloseHandle(handleToClose)
+		handleToClose = 0
+		return
+	}
+	return
+}
@@ -0,0 +1,63 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// ReadRinglogger reads a log file of any format from r, which holds size
+// bytes, without mapping it, so that files copied from elsewhere can be read
+// on any system. The returned ring is a read-only copy, whose records still
+// being written when the file was copied are skipped.
+func ReadRinglogger(r io.ReaderAt, size int64) (*Ringlogger, error) {
+	header := make([]byte, min(size, headerSize))
+	_, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	l, err := parseLayout(header, size)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]uint64, (l.fileSize()+7)/8)
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), l.fileSize())
+	n, err := r.ReadAt(b, 0)
+	if n < len(b) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &Ringlogger{
+		tag:      "DMP",
+		view:     unsafe.Pointer(&words[0]),
+		layout:   l,
+		readOnly: true,
+		frozen:   true,
+	}, nil
+}
+
+// ReadRingloggerFile is like ReadRinglogger, but reads the file at filename.
+func ReadRingloggerFile(filename string) (*Ringlogger, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	rl, err := ReadRinglogger(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read log file %#q: %w", filename, err)
+	}
+	return rl, nil
+}
@@ -0,0 +1,146 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// newMemoryRinglogger returns a writable ring kept in memory, along with the
+// bytes of the file it would be.
+func newMemoryRinglogger(geometry Geometry, tag string) (*Ringlogger, []byte) {
+	l := newLayout(geometry)
+	words := make([]uint64, l.fileSize()/8)
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), l.fileSize())
+	l.putHeader(b)
+	binary.LittleEndian.PutUint32(b[offsetMagic:], l.magic)
+	return &Ringlogger{tag: tag, view: unsafe.Pointer(&words[0]), layout: l}, b
+}
+
+func TestReadRinglogger(t *testing.T) {
+	geometry := Geometry{Lines: MinLines, LineLength: MinLineLength}
+	rl, file := newMemoryRinglogger(geometry, "RDR")
+	for i := 0; i < MinLines+10; i++ {
+		fmt.Fprintf(rl, "[tun] line %d", i)
+	}
+	rl.WriteRecord(LevelError, "", []byte(strings.Repeat("long ", 100)), 1)
+
+	// Leave the record after the first one still in the ring half written.
+	copied := bytes.Clone(file)
+	pending := rl.layout.recordOffset(rl.oldestSequence(rl.lastSequence()) + 1)
+	binary.LittleEndian.PutUint64(copied[pending+recordOffsetEnd:], 0)
+
+	read, err := ReadRinglogger(bytes.NewReader(copied), int64(len(copied)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer read.Close()
+	if read.Geometry() != geometry {
+		t.Errorf("Expected geometry %v, but got %v", geometry, read.Geometry())
+	}
+	lines, _, _ := read.FollowFromCursor(CursorAll)
+	if len(lines) != MinLines-4 {
+		t.Fatalf("Expected %d lines, but got %d", MinLines-4, len(lines))
+	}
+	if lines[0].Message != "line 14" || lines[1].Message != "line 16" || lines[0].Tunnel != "tun" {
+		t.Errorf("Expected the half written line to be skipped, but got %#q and %#q", lines[0].Line, lines[1].Line)
+	}
+	if last := lines[len(lines)-1]; last.Level != LevelError || last.Message != strings.TrimSpace(strings.Repeat("long ", 100)) {
+		t.Errorf("Expected the long line to be reassembled, but got %#q", last.Line)
+	}
+	if _, err = read.Write([]byte("more")); err == nil {
+		t.Error("Expected a copy of a file not to be writable")
+	}
+
+	var out bytes.Buffer
+	err = Dump(read, &out, &DumpOptions{Continuous: true, Filter: Filter{MaxLevel: LevelError}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[RDR] long long") || strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("Expected only the error to be dumped, but got %#q", out.String())
+	}
+
+	_, err = ReadRinglogger(bytes.NewReader(copied[:len(copied)-1]), int64(len(copied)))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected a truncated file to be rejected, but got %v", err)
+	}
+	if _, err = ReadRinglogger(bytes.NewReader(copied[:headerSize]), headerSize); err != errInvalidGeometry {
+		t.Errorf("Expected a file too short for its geometry to be rejected, but got %v", err)
+	}
+}
+
+func TestReadLegacyRinglogger(t *testing.T) {
+	l := layout{Geometry: DefaultGeometry, magic: legacyMagic, headerSize: 8, recordSize: legacyRecordOffsetLine + defaultLineLength}
+	file := make([]byte, l.fileSize())
+	binary.LittleEndian.PutUint32(file[offsetMagic:], legacyMagic)
+	binary.LittleEndian.PutUint32(file[offsetNextIndex:], 2)
+	for i, line := range []string{"[MGR] Starting", "[TUN] [office] Interface up"} {
+		record := file[l.recordOffset(uint64(i+1)):]
+		binary.LittleEndian.PutUint64(record, uint64(i+1)*1e9)
+		copy(record[legacyRecordOffsetLine:], line)
+	}
+	rl, err := ReadRinglogger(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 2 || lines[0].Tag != "MGR" || lines[1].Tunnel != "office" || lines[1].Message != "Interface up" {
+		t.Errorf("Expected legacy lines to be parsed, but got %#v", lines)
+	}
+}
+
+// The runtime cannot allocate while crashing, so its output must be written
+// without allocating.
+func TestWriteWithoutAllocating(t *testing.T) {
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "RUN")
+	record := make([]byte, rl.layout.recordSize)
+	line := []byte(strings.Repeat("goroutine 1 [running]: ", 20))
+	allocs := testing.AllocsPerRun(100, func() {
+		rl.writeMessageUsing(record, LevelError, rl.tag, "", line, 1)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, but got %v", allocs)
+	}
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) == 0 || lines[len(lines)-1].Message != string(line) {
+		t.Error("Expected the line to be written")
+	}
+}
+
+func TestFollowStalePending(t *testing.T) {
+	rl, file := newMemoryRinglogger(Geometry{Lines: MinLines * 2, LineLength: MinLineLength}, "STL")
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(rl, "line %d", i)
+	}
+	// A writer that died while writing the second line left it pending.
+	binary.LittleEndian.PutUint64(file[rl.layout.recordOffset(2)+recordOffsetEnd:], 0)
+	lines, cursor, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 1 || cursor != 2 {
+		t.Fatalf("Expected to wait for the pending line, but got %d lines and cursor %d", len(lines), cursor)
+	}
+	for i := 3; i < stalePendingRecords+1; i++ {
+		fmt.Fprintf(rl, "line %d", i)
+	}
+	lines, cursor, missed := rl.FollowFromCursor(cursor)
+	if len(lines) != 0 || cursor != 2 || missed != 0 {
+		t.Fatalf("Expected to still wait for the pending line, but got %d lines and cursor %d", len(lines), cursor)
+	}
+	fmt.Fprintf(rl, "line %d", stalePendingRecords+1)
+	lines, cursor, missed = rl.FollowFromCursor(cursor)
+	if missed != 1 || len(lines) != stalePendingRecords || lines[0].Message != "line 2" {
+		t.Errorf("Expected the stale line to be skipped, but got %d missed and %d lines", missed, len(lines))
+	}
+	if cursor != rl.lastSequence()+1 {
+		t.Errorf("Expected to follow to the end, but stopped at %d", cursor)
+	}
+}
@@ -0,0 +1,163 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// RedactionLevel selects what is masked in exported lines.
+type RedactionLevel int
+
+const (
+	RedactNone      RedactionLevel = iota
+	RedactKeys                     // Base64 keys, such as public, private and pre-shared keys.
+	RedactAddresses                // Keys, as well as IP addresses and host names.
+)
+
+func (l RedactionLevel) String() string {
+	switch l {
+	case RedactNone:
+		return "none"
+	case RedactKeys:
+		return "keys"
+	case RedactAddresses:
+		return "addresses"
+	default:
+		return fmt.Sprintf("redaction%d", int(l))
+	}
+}
+
+func ParseRedactionLevel(s string) (RedactionLevel, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return RedactNone, nil
+	case "keys":
+		return RedactKeys, nil
+	case "addresses":
+		return RedactAddresses, nil
+	}
+	return 0, fmt.Errorf("Invalid redaction level %#q", s)
+}
+
+var (
+	keyPattern = `[A-Za-z0-9+/]{42}[AEIMQUYcgkosw048]=`
+	// Keys may start with + or /, which \b does not take for the start of a
+	// word, so the character before a key is matched instead, and kept.
+	keyBoundary     = `(?:^|[^A-Za-z0-9+/])(` + keyPattern + `)`
+	redactKeys      = regexp.MustCompile(keyBoundary)
+	redactAddresses = regexp.MustCompile(keyBoundary + `|` +
+		`(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}(?:\.[0-9]{1,3}){0,3}|` +
+		`\b[0-9]{1,3}(?:\.[0-9]{1,3}){3}\b|` +
+		`\b(?:[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}\b`)
+)
+
+// Names that look like host names, but are rather files of the sort found in
+// log lines.
+var fileSuffixes = [...]string{".bin", ".conf", ".dll", ".exe", ".go", ".json", ".log", ".msi", ".sys", ".txt", ".zip"}
+
+// Redactor masks secrets in lines. Each distinct secret is replaced by the same
+// pseudonym every time, so that lines of one export can still be correlated,
+// while different redactors choose pseudonyms independently.
+type Redactor struct {
+	level      RedactionLevel
+	pseudonyms map[string]string
+	counts     map[string]int
+}
+
+// NewRedactor returns a redactor for a single export, or nil for RedactNone,
+// which is valid and masks nothing.
+func NewRedactor(level RedactionLevel) *Redactor {
+	if level == RedactNone {
+		return nil
+	}
+	return &Redactor{level: level, pseudonyms: make(map[string]string), counts: make(map[string]int)}
+}
+
+func (r *Redactor) pseudonym(kind, secret string) string {
+	if pseudonym, ok := r.pseudonyms[secret]; ok {
+		return pseudonym
+	}
+	r.counts[kind]++
+	pseudonym := fmt.Sprintf("<%s-%d>", kind, r.counts[kind])
+	r.pseudonyms[secret] = pseudonym
+	return pseudonym
+}
+
+// replace returns the pseudonym of match, which is an address or host name,
+// unless it is harmless, or, being followed by rest, is rather a Go symbol.
+func (r *Redactor) replace(match, rest string) string {
+	if addr, err := netip.ParseAddr(match); err == nil {
+		if addr.IsUnspecified() || addr.IsLoopback() {
+			return match
+		}
+		if addr.Is4() {
+			return r.pseudonym("ipv4", match)
+		}
+		return r.pseudonym("ipv6", match)
+	}
+	if strings.ContainsRune(match, ':') || !strings.ContainsRune(match, '.') {
+		return match
+	}
+	// Functions in stack traces, such as runtime.gopanic(...).
+	if strings.HasPrefix(rest, "(") {
+		return match
+	}
+	lower := strings.ToLower(match)
+	if lower == "localhost" {
+		return match
+	}
+	for _, suffix := range fileSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return match
+		}
+	}
+	return r.pseudonym("host", lower)
+}
+
+// Redact masks secrets in s.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	pattern := redactAddresses
+	if r.level == RedactKeys {
+		pattern = redactKeys
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		start, end := match[0], match[1]
+		if match[2] >= 0 {
+			start = match[2]
+			b.WriteString(s[last:start])
+			b.WriteString(r.pseudonym("key", s[start:end]))
+		} else {
+			b.WriteString(s[last:start])
+			b.WriteString(r.replace(s[start:end], s[end:]))
+		}
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// RedactLine returns line with secrets in its message masked.
+func (r *Redactor) RedactLine(line FollowLine) FollowLine {
+	if r == nil || len(line.Line) == 0 {
+		return line
+	}
+	line.Message = r.Redact(line.Message)
+	if len(line.Tag) > 0 {
+		line.Line = formatLine(line.Tag, line.Tunnel, line.Message)
+	} else {
+		line.Line = line.Message
+	}
+	return line
+}
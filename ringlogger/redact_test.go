@@ -0,0 +1,49 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestRedactKeyBoundaries(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"+ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE= at start", "<key-1> at start"},
+		{"/ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE= at start", "<key-1> at start"},
+		{"peer +ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE= after a space", "peer <key-1> after a space"},
+		{"public_key=/ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE= after =", "public_key=<key-1> after ="},
+		{"(+ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE=,/ZVGNFjmmrmSOsUdChmv/2bcA0bLM6eqjh2UKOZCEnE=)", "(<key-1>,<key-2>)"},
+	}
+	for _, test := range tests {
+		if actual := NewRedactor(RedactKeys).Redact(test.line); actual != test.expected {
+			t.Errorf("Expected %#q, but got %#q", test.expected, actual)
+		}
+	}
+
+	var key [32]byte
+	for i := 0; i < 10000; i++ {
+		rand.Read(key[:])
+		encoded := base64.StdEncoding.EncodeToString(key[:])
+		for _, line := range []string{encoded, "peer " + encoded, "key=" + encoded} {
+			if actual := NewRedactor(RedactAddresses).Redact(line); strings.Contains(actual, encoded) {
+				t.Fatalf("Key was not redacted from %#q: %#q", line, actual)
+			}
+		}
+	}
+}
+
+func TestRedactGoSymbols(t *testing.T) {
+	const line = "runtime.gopanic({0x1}) main.main() at vpn.example.com"
+	if actual := NewRedactor(RedactAddresses).Redact(line); actual != "runtime.gopanic({0x1}) main.main() at <host-1>" {
+		t.Errorf("Unexpected redaction %#q", actual)
+	}
+}
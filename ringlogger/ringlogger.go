@@ -7,100 +7,43 @@ package ringlogger
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
-	"runtime"
-	"strconv"
 	"sync/atomic"
 	"time"
 	"unsafe"
-
-	"golang.org/x/sys/windows"
-)
-
-const (
-	maxLogLineLength = 512
-	maxTagLength     = 5
-	maxLines         = 2048
-	magic            = 0xbadbabe
 )
 
-type logLine struct {
-	timeNs int64
-	line   [maxLogLineLength]byte
-}
-
-type logMem struct {
-	magic     uint32
-	nextIndex uint32
-	lines     [maxLines]logLine
-}
-
 type Ringlogger struct {
 	tag      string
 	file     *os.File
-	mapping  windows.Handle
-	log      *logMem
+	view     unsafe.Pointer
+	unmap    func() // Releases the view, unless it is a copy of a file.
+	layout   layout
 	readOnly bool
+	frozen   bool // The view is a copy of a file, which no longer changes.
 }
 
-func NewRinglogger(filename, tag string) (*Ringlogger, error) {
-	if len(tag) > maxTagLength {
-		return nil, windows.ERROR_LABEL_TOO_LONG
+func (rl *Ringlogger) importLines(lines []FollowLine) {
+	for i := range lines {
+		rl.writeMessage(lines[i].Level, lines[i].Tag, lines[i].Tunnel, []byte(lines[i].Message), lines[i].Stamp.UnixNano())
 	}
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o600)
-	if err != nil {
-		return nil, err
-	}
-	err = file.Truncate(int64(unsafe.Sizeof(logMem{})))
-	if err != nil {
-		return nil, err
-	}
-	mapping, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READWRITE, 0, 0, nil)
-	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
-		return nil, err
-	}
-	rl, err := newRingloggerFromMappingHandle(mapping, tag, windows.FILE_MAP_WRITE)
-	if err != nil {
-		windows.CloseHandle(mapping)
-		return nil, err
-	}
-	rl.file = file
-	return rl, nil
 }
 
-func NewRingloggerFromInheritedMappingHandle(handleStr, tag string) (*Ringlogger, error) {
-	handle, err := strconv.ParseUint(handleStr, 10, 64)
-	if err != nil {
-		return nil, err
+// lastSequence returns the sequence number of the last record reserved by a
+// writer, which is the number of records written, including any that are
+// still being written.
+func (rl *Ringlogger) lastSequence() uint64 {
+	if rl.layout.sequenced() {
+		return atomic.LoadUint64((*uint64)(unsafe.Add(rl.view, offsetLastSequence)))
 	}
-	return newRingloggerFromMappingHandle(windows.Handle(handle), tag, windows.FILE_MAP_READ)
+	return uint64(atomic.LoadUint32((*uint32)(unsafe.Add(rl.view, offsetNextIndex))))
 }
 
-func newRingloggerFromMappingHandle(mappingHandle windows.Handle, tag string, access uint32) (*Ringlogger, error) {
-	view, err := windows.MapViewOfFile(mappingHandle, access, 0, 0, 0)
-	if err != nil {
-		return nil, err
-	}
-	log := (*logMem)(unsafe.Pointer(view))
-	if log.magic != magic {
-		bytes := (*[unsafe.Sizeof(logMem{})]byte)(unsafe.Pointer(log))
-		for i := range bytes {
-			bytes[i] = 0
-		}
-		log.magic = magic
-		windows.FlushViewOfFile(view, uintptr(len(bytes)))
-	}
-
-	rl := &Ringlogger{
-		tag:      tag,
-		mapping:  mappingHandle,
-		log:      log,
-		readOnly: access&windows.FILE_MAP_WRITE == 0,
-	}
-	runtime.SetFinalizer(rl, (*Ringlogger).Close)
-	return rl, nil
+func (rl *Ringlogger) recordWords(sequence uint64) []uint64 {
+	return unsafe.Slice((*uint64)(unsafe.Add(rl.view, rl.layout.recordOffset(sequence))), rl.layout.recordSize/8)
 }
 
 func (rl *Ringlogger) Write(p []byte) (n int, err error) {
@@ -109,64 +52,175 @@ func (rl *Ringlogger) Write(p []byte) (n int, err error) {
 	return rl.WriteWithTimestamp(p, ts)
 }
 
+// WriteWithTimestamp writes p at the default level. If p starts with a
+// bracketed tunnel name, as is conventional for tunnel-specific lines, that
+// name is recorded as the tunnel of the line.
 func (rl *Ringlogger) WriteWithTimestamp(p []byte, ts int64) (n int, err error) {
+	ret := len(p)
+	tunnel, message := splitTunnelPrefix(bytes.TrimSpace(p))
+	_, err = rl.WriteRecord(DefaultLevel, tunnel, message, ts)
+	if err != nil {
+		return 0, err
+	}
+	return ret, nil
+}
+
+// WriteRecord writes p at the given level, unless that is more verbose than
+// the current verbosity of the writer and tunnel, in which case it is dropped.
+func (rl *Ringlogger) WriteRecord(level Level, tunnel string, p []byte, ts int64) (n int, err error) {
 	if rl.readOnly {
 		return 0, io.ErrShortWrite
 	}
 	ret := len(p)
+	if level > CurrentVerbosity().Level(rl.tag, tunnel) {
+		return ret, nil
+	}
 	p = bytes.TrimSpace(p)
 	if len(p) == 0 {
 		return ret, nil
 	}
-
-	if rl.log == nil {
+	if rl.view == nil || !rl.layout.writable() {
 		return 0, io.EOF
 	}
+	rl.writeMessage(level, rl.tag, tunnel, p, ts)
+	return ret, nil
+}
+
+func (rl *Ringlogger) writeMessage(level Level, tag, tunnel string, p []byte, ts int64) {
+	rl.writeMessageUsing(make([]byte, rl.layout.recordSize), level, tag, tunnel, p, ts)
+}
 
-	// Race: More than maxLines writers and this will clash.
-	index := atomic.AddUint32(&rl.log.nextIndex, 1) - 1
-	line := &rl.log.lines[index%maxLines]
+// writeMessageUsing is like writeMessage, but does not allocate, using record,
+// which must be at least as long as a record, to encode each record in turn.
+func (rl *Ringlogger) writeMessageUsing(record []byte, level Level, tag, tunnel string, p []byte, ts int64) {
+	n := rl.layout.messageRecords(len(p))
 
-	// Race: Before this line executes, we'll display old data after new data.
-	atomic.StoreInt64(&line.timeNs, 0)
-	for i := range line.line {
-		line.line[i] = 0
+	// Race: More than as many writers as there are lines and this will clash,
+	// which readers notice as the record having been overwritten.
+	last := atomic.AddUint64((*uint64)(unsafe.Add(rl.view, offsetLastSequence)), uint64(n))
+	for i := 0; i < n; i++ {
+		rl.layout.encodeMessagePart(record, i, n, level, tag, tunnel, p, ts)
+		sequence := last - uint64(n) + uint64(i) + 1
+		words := rl.recordWords(sequence)
+		atomic.StoreUint64(&words[recordOffsetBegin/8], sequence)
+		for i := recordBase / 8; i < len(words); i++ {
+			atomic.StoreUint64(&words[i], binary.LittleEndian.Uint64(record[i*8:]))
+		}
+		atomic.StoreUint64(&words[recordOffsetEnd/8], sequence) // Only let the line be seen after the other writes have completed.
 	}
+}
+
+// stalePendingRecords is how far behind the last record a record may still be
+// pending before readers give up on it, rather than waiting for a writer that
+// has died while writing it. Live writers complete their records as soon as
+// they have reserved them, so are never so far behind.
+const stalePendingRecords = MinLines / 2
+
+type recordState int
+
+const (
+	recordComplete recordState = iota
+	recordPending
+	recordOverwritten
+)
 
-	textLen := 3 + len(p) + len(rl.tag)
-	if textLen > maxLogLineLength-1 {
-		p = p[:maxLogLineLength-1-3-len(rl.tag)]
-		textLen = maxLogLineLength - 1
+// readRecord decodes record number sequence, using buf, which must be at least
+// as long as a record, to hold a copy of it.
+func (rl *Ringlogger) readRecord(sequence uint64, buf []byte) (FollowLine, uint16, recordState) {
+	words := rl.recordWords(sequence)
+	var end uint64
+	if rl.layout.sequenced() {
+		end = atomic.LoadUint64(&words[recordOffsetEnd/8])
 	}
-	line.line[textLen] = 0
-	line.line[0] = 0 // Null out the beginning and only let it extend after the other writes have completed
-	copy(line.line[1:], rl.tag)
-	line.line[1+len(rl.tag)] = ']'
-	line.line[2+len(rl.tag)] = ' '
-	copy(line.line[3+len(rl.tag):], p[:])
-	line.line[0] = '['
-	atomic.StoreInt64(&line.timeNs, ts)
+	for i := range words {
+		binary.LittleEndian.PutUint64(buf[i*8:], atomic.LoadUint64(&words[i]))
+	}
+	line, flags, ok := rl.layout.decodeRecord(buf)
+	if !rl.layout.sequenced() {
+		if !ok {
+			return line, 0, recordPending
+		}
+		return line, 0, recordComplete
+	}
+	begin := atomic.LoadUint64(&words[recordOffsetBegin/8])
+	if begin > sequence || end > sequence {
+		return FollowLine{}, 0, recordOverwritten
+	} else if begin != sequence || end != sequence {
+		return FollowLine{}, 0, recordPending
+	}
+	return line, flags, recordComplete
+}
 
-	return ret, nil
+// readMessage reassembles the message starting at record number sequence,
+// returning the sequence number of the record following the records read. If
+// some of the records of the message were overwritten, or sequence is not its
+// first record, the state is recordOverwritten, and all of the records read
+// are lost.
+func (rl *Ringlogger) readMessage(sequence, last uint64, buf []byte) (FollowLine, uint64, recordState) {
+	line, flags, state := rl.readRecord(sequence, buf)
+	next := sequence + 1
+	if state != recordComplete {
+		return line, next, state
+	}
+	if flags&recordContinuation != 0 {
+		return FollowLine{}, next, recordOverwritten
+	}
+	if flags&recordContinues == 0 {
+		return line, next, state
+	}
+	message := []byte(line.Message)
+	for flags&recordContinues != 0 {
+		if next > last {
+			return FollowLine{}, next, recordPending
+		}
+		var part FollowLine
+		part, flags, state = rl.readRecord(next, buf)
+		if state == recordPending {
+			return FollowLine{}, next, recordPending
+		} else if state == recordOverwritten {
+			return FollowLine{}, next + 1, recordOverwritten
+		} else if flags&recordContinuation == 0 {
+			return FollowLine{}, next, recordOverwritten
+		}
+		message = append(message, part.Message...)
+		next++
+	}
+	line.Message = string(message)
+	line.Line = formatLine(line.Tag, line.Tunnel, line.Message)
+	return line, next, recordComplete
+}
+
+// oldestSequence returns the sequence number of the oldest record still in the
+// ring, given the last one.
+func (rl *Ringlogger) oldestSequence(last uint64) uint64 {
+	if last > uint64(rl.layout.Lines) {
+		return last - uint64(rl.layout.Lines) + 1
+	}
+	return 1
 }
 
 func (rl *Ringlogger) WriteTo(out io.Writer) (n int64, err error) {
-	if rl.log == nil {
+	return rl.WriteRedactedTo(out, nil)
+}
+
+// WriteRedactedTo is like WriteTo, but masks secrets using redactor, which may
+// be nil.
+func (rl *Ringlogger) WriteRedactedTo(out io.Writer, redactor *Redactor) (n int64, err error) {
+	if rl.view == nil {
 		return 0, io.EOF
 	}
-	log := *rl.log
-	i := log.nextIndex
-	for l := uint32(0); l < maxLines; l++ {
-		line := &log.lines[(i+l)%maxLines]
-		if line.timeNs == 0 {
-			continue
-		}
-		index := bytes.IndexByte(line.line[:], 0)
-		if index < 1 {
+	buf := make([]byte, rl.layout.recordSize)
+	last := rl.lastSequence()
+	for sequence := rl.oldestSequence(last); sequence <= last; {
+		var line FollowLine
+		var state recordState
+		line, sequence, state = rl.readMessage(sequence, last, buf)
+		if state != recordComplete || len(line.Line) == 0 {
 			continue
 		}
+		line = redactor.RedactLine(line)
 		var bytes int
-		bytes, err = fmt.Fprintf(out, "%s: %s\n", time.Unix(0, line.timeNs).Format("2006-01-02 15:04:05.000000"), line.line[:index])
+		bytes, err = line.WriteText(out)
 		if err != nil {
 			return
 		}
@@ -175,76 +229,93 @@ func (rl *Ringlogger) WriteTo(out io.Writer) (n int64, err error) {
 	return
 }
 
-const CursorAll = ^uint32(0)
+// A cursor is the sequence number of the next record to be read.
+const CursorAll = ^uint64(0)
 
 type FollowLine struct {
-	Line  string
-	Stamp time.Time
+	Line    string
+	Stamp   time.Time
+	Level   Level
+	Tag     string
+	Tunnel  string
+	Message string
 }
 
-func (rl *Ringlogger) FollowFromCursor(cursor uint32) (followLines []FollowLine, nextCursor uint32) {
-	followLines = make([]FollowLine, 0, maxLines)
-	nextCursor = cursor
+// Geometry returns the geometry of the file, which may differ from the one
+// requested when opening it.
+func (rl *Ringlogger) Geometry() Geometry {
+	return rl.layout.Geometry
+}
 
-	if rl.log == nil {
+// FollowFromCursor returns the lines written from cursor on, which is either
+// CursorAll or the cursor returned by the previous call, along with the cursor
+// for the next call. It stops at the first line that is still being written.
+// Records that were overwritten before they could be read, either because the
+// ring wrapped around since the previous call or while reading, are counted in
+// missed, unless reading from CursorAll, which is whatever is left in the ring.
+func (rl *Ringlogger) FollowFromCursor(cursor uint64) (followLines []FollowLine, nextCursor uint64, missed uint64) {
+	nextCursor = cursor
+	if rl.view == nil {
 		return
 	}
-	log := *rl.log
-
-	i := cursor
-	if cursor == CursorAll {
-		i = log.nextIndex
+	last := rl.lastSequence()
+	oldest := rl.oldestSequence(last)
+	all := cursor == CursorAll
+	if all || cursor > last+1 {
+		cursor = oldest
+	} else if cursor < oldest {
+		missed = oldest - cursor
+		cursor = oldest
 	}
-
-	for l := 0; l < maxLines; l++ {
-		line := &log.lines[i%maxLines]
-		if cursor != CursorAll && i%maxLines == log.nextIndex%maxLines {
-			break
-		}
-		if line.timeNs == 0 {
-			if cursor == CursorAll {
-				i++
-				continue
-			} else {
+	buf := make([]byte, rl.layout.recordSize)
+	for cursor <= last {
+		line, next, state := rl.readMessage(cursor, last, buf)
+		if state == recordPending {
+			if !rl.frozen && last-cursor < stalePendingRecords {
 				break
 			}
+			// A copy of a file never sees the record completed, as its
+			// writer has likely died while writing it, as has one whose record
+			// is still pending so far behind the last.
+			next = max(next, cursor+1)
+			if !rl.frozen && !all {
+				missed += next - cursor
+			}
+		} else if state == recordOverwritten {
+			if !all {
+				missed += next - cursor
+			}
+		} else if len(line.Line) > 0 {
+			followLines = append(followLines, line)
 		}
-		index := bytes.IndexByte(line.line[:], 0)
-		if index > 0 {
-			followLines = append(followLines, FollowLine{string(line.line[:index]), time.Unix(0, line.timeNs)})
-		}
-		i++
-		nextCursor = i % maxLines
+		cursor = next
 	}
+	nextCursor = cursor
 	return
 }
 
+// MissedLine returns a line standing in for lines that were overwritten before
+// they could be read.
+func MissedLine(missed uint64) FollowLine {
+	message := fmt.Sprintf("%d lines were overwritten before they could be read", missed)
+	return FollowLine{
+		Line:    formatLine("LOG", "", message),
+		Stamp:   time.Now(),
+		Level:   LevelWarning,
+		Tag:     "LOG",
+		Message: message,
+	}
+}
+
 func (rl *Ringlogger) Close() error {
 	if rl.file != nil {
 		rl.file.Close()
 		rl.file = nil
 	}
-	if rl.log != nil {
-		windows.UnmapViewOfFile((uintptr)(unsafe.Pointer(rl.log)))
-		rl.log = nil
-	}
-	if rl.mapping != 0 {
-		windows.CloseHandle(rl.mapping)
-		rl.mapping = 0
+	if rl.unmap != nil {
+		rl.unmap()
+		rl.unmap = nil
 	}
+	rl.view = nil
 	return nil
 }
-
-func (rl *Ringlogger) ExportInheritableMappingHandle() (handleToClose windows.Handle, err error) {
-	handleToClose, err = windows.CreateFileMapping(windows.Handle(rl.file.Fd()), nil, windows.PAGE_READONLY, 0, 0, nil)
-	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
-		return
-	}
-	err = windows.SetHandleInformation(handleToClose, windows.HANDLE_FLAG_INHERIT, windows.HANDLE_FLAG_INHERIT)
-	if err != nil {
-		windows.CloseHandle(handleToClose)
-		handleToClose = 0
-		return
-	}
-	return
-}
@@ -0,0 +1,291 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	syslogQueueLength = 4096
+	syslogMaxDatagram = 2048
+	syslogFacility    = 3 // daemon
+	syslogAppName     = "AmneziaWG"
+	syslogMinBackoff  = time.Second
+	syslogMaxBackoff  = time.Minute * 5
+	// syslogSDID names the structured data holding the tunnel of a line. As
+	// AmneziaWG has no private enterprise number of its own, it uses 32473,
+	// which RFC 5612 reserves for documentation.
+	syslogSDID = "amneziawg@32473"
+)
+
+// SyslogForwarder sends the lines of a ring to a syslog receiver in the format
+// of RFC 5424. Lines are queued in memory while the receiver is unreachable,
+// and the oldest are dropped once the queue is full, so that writers of the
+// ring are never held up.
+type SyslogForwarder struct {
+	rl       *Ringlogger
+	network  string
+	address  string
+	tls      *tls.Config
+	hostname string
+	queue    chan FollowLine
+	dropped  atomic.Uint64
+	conn     net.Conn
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// ParseSyslogServer parses a receiver of the form udp://host[:port],
+// tcp://host[:port] or tls://host[:port], returning the network and address to
+// dial, and whether to use TLS.
+func ParseSyslogServer(server string) (network, address string, useTLS bool, err error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return
+	}
+	if len(u.Host) == 0 || (len(u.Path) > 0 && u.Path != "/") {
+		err = fmt.Errorf("Invalid syslog server %#q", server)
+		return
+	}
+	var port string
+	switch strings.ToLower(u.Scheme) {
+	case "udp":
+		network, port = "udp", "514"
+	case "tcp":
+		network, port = "tcp", "601"
+	case "tls":
+		network, port, useTLS = "tcp", "6514", true
+	default:
+		err = fmt.Errorf("Invalid syslog protocol %#q", u.Scheme)
+		return
+	}
+	if len(u.Port()) > 0 {
+		port = u.Port()
+	}
+	address = net.JoinHostPort(u.Hostname(), port)
+	return
+}
+
+// StartSyslogForwarder forwards the lines of rl stamped after since to server,
+// as parsed by ParseSyslogServer.
+func StartSyslogForwarder(rl *Ringlogger, server string, since time.Time) (*SyslogForwarder, error) {
+	network, address, useTLS, err := ParseSyslogServer(server)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) == 0 {
+		hostname = "-"
+	}
+	f := &SyslogForwarder{
+		rl:       rl,
+		network:  network,
+		address:  address,
+		hostname: hostname,
+		queue:    make(chan FollowLine, syslogQueueLength),
+		stop:     make(chan struct{}),
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		f.tls = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	f.stopped.Add(2)
+	go f.follow(since)
+	go f.send()
+	return f, nil
+}
+
+func (f *SyslogForwarder) follow(since time.Time) {
+	defer f.stopped.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	cursor := CursorAll
+	for {
+		var lines []FollowLine
+		var missed uint64
+		lines, cursor, missed = f.rl.FollowFromCursor(cursor)
+		f.dropped.Add(missed)
+		for i := range lines {
+			if lines[i].Stamp.Before(since) {
+				continue
+			}
+			f.enqueue(&lines[i])
+		}
+		select {
+		case <-ticker.C:
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *SyslogForwarder) enqueue(line *FollowLine) {
+	for {
+		select {
+		case f.queue <- *line:
+			return
+		default:
+		}
+		select {
+		case <-f.queue:
+			f.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+func (f *SyslogForwarder) send() {
+	defer f.stopped.Done()
+	defer f.disconnect()
+	backoff := syslogMinBackoff
+	var pending *FollowLine
+	for {
+		if pending == nil {
+			select {
+			case line := <-f.queue:
+				pending = &line
+			case <-f.stop:
+				return
+			}
+		}
+		err := f.connect()
+		if err == nil {
+			if dropped := f.dropped.Swap(0); dropped > 0 {
+				err = f.write(&FollowLine{
+					Stamp:   time.Now(),
+					Level:   LevelWarning,
+					Tag:     "LOG",
+					Message: fmt.Sprintf("Dropped %d lines, as the syslog server was unreachable or the ring wrapped around", dropped),
+				})
+				if err != nil {
+					f.dropped.Add(dropped)
+				}
+			}
+		}
+		if err == nil {
+			err = f.write(pending)
+		}
+		if err == nil {
+			pending = nil
+			backoff = syslogMinBackoff
+			continue
+		}
+		f.disconnect()
+		select {
+		case <-time.After(backoff):
+		case <-f.stop:
+			return
+		}
+		backoff = min(backoff*2, syslogMaxBackoff)
+	}
+}
+
+func (f *SyslogForwarder) connect() error {
+	if f.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+	var err error
+	if f.tls != nil {
+		f.conn, err = tls.DialWithDialer(dialer, f.network, f.address, f.tls)
+	} else {
+		f.conn, err = dialer.Dial(f.network, f.address)
+	}
+	return err
+}
+
+func (f *SyslogForwarder) disconnect() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func (f *SyslogForwarder) write(line *FollowLine) error {
+	message := f.format(line)
+	if f.network == "udp" {
+		message = truncateUTF8(message, syslogMaxDatagram)
+	} else {
+		// Octet counting framing, as per RFC 6587 and RFC 5425.
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	f.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	_, err := f.conn.Write([]byte(message))
+	return err
+}
+
+func syslogSeverity(level Level) int {
+	switch level {
+	case LevelError:
+		return 3
+	case LevelWarning:
+		return 4
+	case LevelVerbose:
+		return 7
+	default:
+		return 6
+	}
+}
+
+func syslogToken(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// truncateUTF8 cuts s to at most n bytes, without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// syslogParamValue escapes the characters that RFC 5424 reserves in the values
+// of structured data parameters.
+var syslogParamValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// format formats a line as an RFC 5424 message, with the tag of the line as
+// its message ID, and the tunnel name, if any, as structured data.
+func (f *SyslogForwarder) format(line *FollowLine) string {
+	structuredData := "-"
+	if len(line.Tunnel) > 0 {
+		structuredData = "[" + syslogSDID + ` tunnel="` + syslogParamValue.Replace(line.Tunnel) + `"]`
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - %s %s %s",
+		syslogFacility*8+syslogSeverity(line.Level),
+		line.Stamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogToken(f.hostname), syslogAppName, syslogToken(line.Tag), structuredData, line.Message)
+}
+
+// Close stops forwarding, discarding whatever is still queued.
+func (f *SyslogForwarder) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		f.stopped.Wait()
+		f.stop = nil
+	}
+	return nil
+}
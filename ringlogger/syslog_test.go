@@ -0,0 +1,43 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	f := &SyslogForwarder{hostname: "host"}
+	stamp := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line     FollowLine
+		expected string
+	}{
+		{FollowLine{Stamp: stamp, Level: LevelInfo, Tag: "MGR", Message: "started"},
+			"<30>1 2026-10-01T12:00:00.000000Z host AmneziaWG - MGR - started"},
+		{FollowLine{Stamp: stamp, Level: LevelError, Tag: "TUN", Tunnel: `of"fi]ce\`, Message: "down"},
+			`<27>1 2026-10-01T12:00:00.000000Z host AmneziaWG - TUN [amneziawg@32473 tunnel="of\"fi\]ce\\"] down`},
+	}
+	for _, test := range tests {
+		if actual := f.format(&test.line); actual != test.expected {
+			t.Errorf("Expected %#q, but got %#q", test.expected, actual)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	s := strings.Repeat("я", syslogMaxDatagram)
+	truncated := truncateUTF8("x"+s, syslogMaxDatagram)
+	if len(truncated) != syslogMaxDatagram-1 || !utf8.ValidString(truncated) {
+		t.Errorf("Expected %d bytes of valid UTF-8, but got %d", syslogMaxDatagram-1, len(truncated))
+	}
+	if truncateUTF8("short", syslogMaxDatagram) != "short" {
+		t.Error("Truncated a short message")
+	}
+}
@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultVerbosity is the most verbose level recorded for components and
+// tunnels that have no level of their own.
+const DefaultVerbosity = LevelInfo
+
+// Verbosity holds the most verbose level recorded for components, by their
+// tag, and for tunnels, by their name. The level of a tunnel takes precedence
+// over that of the component writing a line about it. A Verbosity is not
+// modified once in use; With returns a modified copy instead.
+type Verbosity struct {
+	Components map[string]Level
+	Tunnels    map[string]Level
+}
+
+// Level returns the most verbose level recorded for lines written by the
+// component with the given tag about tunnel, which may be empty.
+func (v *Verbosity) Level(tag, tunnel string) Level {
+	if v == nil {
+		return DefaultVerbosity
+	}
+	if level, ok := v.Tunnels[tunnel]; ok && len(tunnel) > 0 {
+		return level
+	}
+	if level, ok := v.Components[strings.ToUpper(tag)]; ok {
+		return level
+	}
+	return DefaultVerbosity
+}
+
+// With returns a copy of v with the level of the component with the given tag,
+// or of tunnel if tag is empty, set to level, or reset if level is zero.
+func (v *Verbosity) With(tag, tunnel string, level Level) *Verbosity {
+	next := &Verbosity{Components: make(map[string]Level), Tunnels: make(map[string]Level)}
+	if v != nil {
+		for k, l := range v.Components {
+			next.Components[k] = l
+		}
+		for k, l := range v.Tunnels {
+			next.Tunnels[k] = l
+		}
+	}
+	levels, name := next.Tunnels, tunnel
+	if len(tag) > 0 {
+		levels, name = next.Components, strings.ToUpper(tag)
+	}
+	if level == 0 {
+		delete(levels, name)
+	} else {
+		levels[name] = level
+	}
+	return next
+}
+
+// String lists the levels that differ from the default, such as
+// "MGR: verbose, [office]: verbose".
+func (v *Verbosity) String() string {
+	var levels []string
+	if v != nil {
+		for tag, level := range v.Components {
+			levels = append(levels, fmt.Sprintf("%s: %v", tag, level))
+		}
+		for tunnel, level := range v.Tunnels {
+			levels = append(levels, fmt.Sprintf("[%s]: %v", tunnel, level))
+		}
+	}
+	if len(levels) == 0 {
+		return fmt.Sprintf("%v (default)", DefaultVerbosity)
+	}
+	sort.Strings(levels)
+	return strings.Join(levels, ", ")
+}
+
+var verbosity atomic.Pointer[Verbosity]
+
+// SetVerbosity sets the levels recorded by the writers of this process, or
+// resets them to the default if v is nil.
+func SetVerbosity(v *Verbosity) {
+	verbosity.Store(v)
+}
+
+// CurrentVerbosity returns the levels recorded by the writers of this process.
+func CurrentVerbosity() *Verbosity {
+	return verbosity.Load()
+}
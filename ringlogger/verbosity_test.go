@@ -0,0 +1,51 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package ringlogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerbosity(t *testing.T) {
+	defer SetVerbosity(nil)
+	rl, _ := newMemoryRinglogger(Geometry{Lines: MinLines, LineLength: MinLineLength}, "MGR")
+	write := func(level Level, tunnel, message string) {
+		rl.WriteRecord(level, tunnel, []byte(message), time.Now().UnixNano())
+	}
+
+	write(LevelVerbose, "", "hidden by default")
+	write(LevelInfo, "", "shown by default")
+	v := (*Verbosity)(nil).With("", "office", LevelVerbose).With("mgr", "", LevelWarning)
+	SetVerbosity(v)
+	write(LevelInfo, "", "hidden for the component")
+	write(LevelVerbose, "office", "shown for the tunnel")
+	write(LevelVerbose, "home", "hidden for the other tunnel")
+	SetVerbosity(v.With("MGR", "", 0))
+	write(LevelInfo, "", "shown again after reset")
+
+	lines, _, _ := rl.FollowFromCursor(CursorAll)
+	var messages []string
+	for _, line := range lines {
+		messages = append(messages, line.Message)
+	}
+	expected := []string{"shown by default", "shown for the tunnel", "shown again after reset"}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %q, but got %q", expected, messages)
+	}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Errorf("Expected %q, but got %q", expected, messages)
+			break
+		}
+	}
+	if s := v.String(); s != "MGR: warning, [office]: verbose" {
+		t.Errorf("Unexpected description %#q", s)
+	}
+	if s := (*Verbosity)(nil).String(); s != "info (default)" {
+		t.Errorf("Unexpected description %#q", s)
+	}
+}
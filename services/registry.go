@@ -1,6 +1,8 @@
 package services
 
 import (
+	"sync"
+
 	"golang.org/x/sys/windows/registry"
 )
 
@@ -63,3 +65,86 @@ func SetUserKeyString(name string, value string) error {
 	}
 	return nil
 }
+
+// The admin key is the one that conf.AdminBool reads booleans from, which has
+// no counterpart for other types of values, and is opened in the same way.
+const adminRegKey = `Software\AmneziaWG`
+
+var (
+	adminKey     registry.Key
+	adminKeyLock sync.Mutex // The manager's goroutines read admin values at once.
+)
+
+func openAdminKey() (registry.Key, error) {
+	adminKeyLock.Lock()
+	defer adminKeyLock.Unlock()
+	if adminKey != 0 {
+		return adminKey, nil
+	}
+	var err error
+	adminKey, err = registry.OpenKey(registry.LOCAL_MACHINE, adminRegKey, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return 0, err
+	}
+	return adminKey, nil
+}
+
+// AdminKeyInteger returns the DWORD value name of the admin key, and whether
+// it is set.
+func AdminKeyInteger(name string) (uint32, bool) {
+	key, err := openAdminKey()
+	if err != nil {
+		return 0, false
+	}
+	val, valType, err := key.GetIntegerValue(name)
+	if err != nil || valType != registry.DWORD {
+		return 0, false
+	}
+	return uint32(val), true
+}
+
+// AdminKeyString returns the string value name of the admin key, or the empty
+// string if it is not set.
+func AdminKeyString(name string) string {
+	key, err := openAdminKey()
+	if err != nil {
+		return ""
+	}
+	val, _, err := key.GetStringValue(name)
+	if err != nil {
+		return ""
+	}
+	return val
+}
+
+// AdminKeyBinary returns the binary value name of the admin key, and whether
+// it is set.
+func AdminKeyBinary(name string) ([]byte, bool) {
+	key, err := openAdminKey()
+	if err != nil {
+		return nil, false
+	}
+	val, _, err := key.GetBinaryValue(name)
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// SetAdminKeyBinary sets the binary value name of the admin key, or deletes it
+// if value is nil, which requires being elevated.
+func SetAdminKeyBinary(name string, value []byte) error {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, adminRegKey, registry.SET_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	if value == nil {
+		err = key.DeleteValue(name)
+		if err == registry.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+	return key.SetBinaryValue(name, value)
+}
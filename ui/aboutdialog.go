@@ -14,6 +14,8 @@ import (
 	"golang.org/x/sys/windows"
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/l18n"
+	"github.com/amnezia-vpn/amneziawg-windows-client/manager"
+	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
 	"github.com/amnezia-vpn/amneziawg-windows-client/version"
 )
 
@@ -26,6 +28,64 @@ func onAbout(owner walk.Form) {
 	showError(runAboutDialog(owner), owner)
 }
 
+// addUpdateChannelChooser shows the update channel, and lets admins switch it
+// unless the administrator set it by policy.
+func addUpdateChannelChooser(parent walk.Container) error {
+	channel, byAdmin, err := manager.IPCClientUpdateChannel()
+	if err != nil {
+		return nil
+	}
+	channelCP, err := walk.NewComposite(parent)
+	if err != nil {
+		return err
+	}
+	hbl := walk.NewHBoxLayout()
+	hbl.SetMargins(walk.Margins{})
+	channelCP.SetLayout(hbl)
+	walk.NewHSpacer(channelCP)
+	channelLbl, err := walk.NewLabel(channelCP)
+	if err != nil {
+		return err
+	}
+	channelLbl.SetText(l18n.Sprintf("Update channel:"))
+	channelCB, err := walk.NewDropDownBox(channelCP)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(updater.Channels))
+	current := -1
+	for i, c := range updater.Channels {
+		names[i] = channelName(c)
+		if c == channel {
+			current = i
+		}
+	}
+	channelCB.SetModel(names)
+	channelCB.SetCurrentIndex(current)
+	channelCB.SetEnabled(IsAdmin && !byAdmin)
+	if byAdmin {
+		channelCB.SetToolTipText(l18n.Sprintf("The update channel is set by the administrator."))
+	}
+	walk.NewHSpacer(channelCP)
+	channelCB.CurrentIndexChanged().Attach(func() {
+		i := channelCB.CurrentIndex()
+		if i < 0 || updater.Channels[i] == channel {
+			return
+		}
+		err := manager.IPCClientSetUpdateChannel(updater.Channels[i])
+		if err != nil {
+			channelCB.SetCurrentIndex(current)
+			showErrorCustom(parent.Form(), l18n.Sprintf("Unable to switch update channel"), err.Error())
+			return
+		}
+		if i < current {
+			walk.MsgBox(parent.Form(), l18n.Sprintf("Update channel switched"), l18n.Sprintf("Switching to a more stable channel never downgrades, so AmneziaWG %s stays installed until the %s channel has a newer release.", version.Number, channelName(updater.Channels[i])), walk.MsgBoxIconInformation)
+		}
+		channel, current = updater.Channels[i], i
+	})
+	return nil
+}
+
 func runAboutDialog(owner walk.Form) error {
 	if showingAboutDialog != nil {
 		showingAboutDialog.Show()
@@ -97,7 +157,15 @@ func runAboutDialog(owner walk.Form) error {
 		return err
 	}
 	detailsLbl.SetTextAlignment(walk.AlignHCenterVNear)
-	detailsLbl.SetText(l18n.Sprintf("App version: %s\nWintun version: %s\nGo version: %s\nOperating system: %s\nArchitecture: %s", version.Number, version.WintunVersion(), strings.TrimPrefix(runtime.Version(), "go"), version.OsName(), version.Arch()))
+	appVersion := version.Number
+	if version.Current().IsPreRelease() {
+		appVersion = l18n.Sprintf("%s (pre-release)", appVersion)
+	}
+	detailsLbl.SetText(l18n.Sprintf("App version: %s\nWintun version: %s\nGo version: %s\nOperating system: %s\nArchitecture: %s", appVersion, version.WintunVersion(), strings.TrimPrefix(runtime.Version(), "go"), version.OsName(), version.Arch()))
+
+	if err := addUpdateChannelChooser(showingAboutDialog); err != nil {
+		return err
+	}
 
 	copyrightLbl, err := walk.NewTextLabel(showingAboutDialog)
 	if err != nil {
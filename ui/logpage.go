@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/l18n"
+	"github.com/amnezia-vpn/amneziawg-windows-client/manager"
 	"github.com/amnezia-vpn/amneziawg-windows-client/ringlogger"
 	"github.com/lxn/walk"
 )
@@ -22,10 +23,28 @@ const (
 
 type LogPage struct {
 	*walk.TabPage
-	logView *walk.TableView
-	model   *logModel
+	logView   *walk.TableView
+	redaction *walk.ComboBox
+	model     *logModel
+
+	verbosityLabel   *walk.Label
+	verbosityScope   *walk.ComboBox
+	verbosityLevel   *walk.ComboBox
+	verbosityScopes  []verbosityScope
+	verbosity        *ringlogger.Verbosity
+	settingVerbosity bool
+	verbosityCB      *manager.LogVerbosityChangeCallback
+	tunnelsCB        *manager.TunnelsChangeCallback
 }
 
+// verbosityScope is a component, by its tag, or a tunnel, by its name, whose
+// verbosity may be set.
+type verbosityScope struct {
+	tag, tunnel string
+}
+
+var verbosityComponents = [...]string{"MGR", "TUN"}
+
 func NewLogPage() (*LogPage, error) {
 	lp := &LogPage{}
 
@@ -40,6 +59,14 @@ func NewLogPage() (*LogPage, error) {
 
 	lp.Disposing().Attach(func() {
 		lp.model.quit <- true
+		if lp.verbosityCB != nil {
+			lp.verbosityCB.Unregister()
+			lp.verbosityCB = nil
+		}
+		if lp.tunnelsCB != nil {
+			lp.tunnelsCB.Unregister()
+			lp.tunnelsCB = nil
+		}
 	})
 
 	lp.SetTitle(l18n.Sprintf("Log"))
@@ -90,7 +117,7 @@ func NewLogPage() (*LogPage, error) {
 	lp.logView.Columns().Add(stampCol)
 
 	msgCol := walk.NewTableViewColumn()
-	msgCol.SetName("Line")
+	msgCol.SetName("Summary")
 	msgCol.SetTitle(l18n.Sprintf("Log message"))
 	lp.logView.Columns().Add(msgCol)
 
@@ -99,6 +126,10 @@ func NewLogPage() (*LogPage, error) {
 	lp.logView.SetModel(lp.model)
 	setSelectionStatus()
 
+	if err = lp.createVerbosityControls(); err != nil {
+		return nil, err
+	}
+
 	buttonsContainer, err := walk.NewComposite(lp)
 	if err != nil {
 		return nil, err
@@ -106,8 +137,34 @@ func NewLogPage() (*LogPage, error) {
 	buttonsContainer.SetLayout(walk.NewHBoxLayout())
 	buttonsContainer.Layout().SetMargins(walk.Margins{})
 
+	redactionLabel, err := walk.NewLabel(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	redactionLabel.SetText(l18n.Sprintf("Redact when exporting:"))
+
+	if lp.redaction, err = walk.NewDropDownBox(buttonsContainer); err != nil {
+		return nil, err
+	}
+	lp.redaction.SetModel([]string{
+		l18n.Sprintf("Nothing"),
+		l18n.Sprintf("Keys"),
+		l18n.Sprintf("Keys and addresses"),
+	})
+	lp.redaction.SetCurrentIndex(int(ringlogger.RedactKeys))
+
 	walk.NewHSpacer(buttonsContainer)
 
+	// The bundle holds the configurations, so only admins may save it.
+	if IsAdmin {
+		diagnosticsButton, err := walk.NewPushButton(buttonsContainer)
+		if err != nil {
+			return nil, err
+		}
+		diagnosticsButton.SetText(l18n.Sprintf("Save &diagnostics…"))
+		diagnosticsButton.Clicked().Attach(lp.onSaveDiagnostics)
+	}
+
 	saveButton, err := walk.NewPushButton(buttonsContainer)
 	if err != nil {
 		return nil, err
@@ -120,6 +177,145 @@ func NewLogPage() (*LogPage, error) {
 	return lp, nil
 }
 
+func (lp *LogPage) createVerbosityControls() error {
+	container, err := walk.NewComposite(lp)
+	if err != nil {
+		return err
+	}
+	container.SetLayout(walk.NewHBoxLayout())
+	container.Layout().SetMargins(walk.Margins{})
+
+	if lp.verbosityLabel, err = walk.NewLabel(container); err != nil {
+		return err
+	}
+	walk.NewHSpacer(container)
+
+	if IsAdmin {
+		if lp.verbosityScope, err = walk.NewDropDownBox(container); err != nil {
+			return err
+		}
+		lp.verbosityScope.CurrentIndexChanged().Attach(lp.onVerbosityScopeChanged)
+		if lp.verbosityLevel, err = walk.NewDropDownBox(container); err != nil {
+			return err
+		}
+		lp.verbosityLevel.SetModel([]string{
+			l18n.Sprintf("Default"),
+			l18n.Sprintf("Errors"),
+			l18n.Sprintf("Warnings"),
+			l18n.Sprintf("Information"),
+			l18n.Sprintf("Verbose"),
+		})
+		lp.verbosityLevel.CurrentIndexChanged().Attach(lp.onVerbosityLevelChanged)
+		resetButton, err := walk.NewPushButton(container)
+		if err != nil {
+			return err
+		}
+		resetButton.SetText(l18n.Sprintf("&Reset verbosity"))
+		resetButton.Clicked().Attach(func() {
+			lp.setVerbosity(verbosityScope{}, 0)
+		})
+		lp.updateVerbosityScopes()
+		lp.tunnelsCB = manager.IPCClientRegisterTunnelsChange(func() {
+			lp.Synchronize(lp.updateVerbosityScopes)
+		})
+	}
+
+	lp.verbosityCB = manager.IPCClientRegisterLogVerbosityChange(func(verbosity *ringlogger.Verbosity) {
+		lp.Synchronize(func() {
+			lp.showVerbosity(verbosity)
+		})
+	})
+	verbosity, err := manager.IPCClientLogVerbosity()
+	if err == nil {
+		lp.showVerbosity(verbosity)
+	}
+	return nil
+}
+
+func (lp *LogPage) updateVerbosityScopes() {
+	scopes := make([]verbosityScope, 0, len(verbosityComponents))
+	names := make([]string, 0, len(verbosityComponents))
+	for _, tag := range verbosityComponents {
+		scopes = append(scopes, verbosityScope{tag: tag})
+		names = append(names, l18n.Sprintf("Component %s", tag))
+	}
+	if tunnels, err := manager.IPCClientTunnels(); err == nil {
+		for _, tunnel := range tunnels {
+			scopes = append(scopes, verbosityScope{tunnel: tunnel.Name})
+			names = append(names, l18n.Sprintf("Tunnel %s", tunnel.Name))
+		}
+	}
+	current := lp.verbosityScope.CurrentIndex()
+	var selected verbosityScope
+	if current >= 0 && current < len(lp.verbosityScopes) {
+		selected = lp.verbosityScopes[current]
+	}
+	lp.verbosityScopes = scopes
+	lp.settingVerbosity = true
+	lp.verbosityScope.SetModel(names)
+	index := 0
+	for i := range scopes {
+		if scopes[i] == selected {
+			index = i
+			break
+		}
+	}
+	lp.verbosityScope.SetCurrentIndex(index)
+	lp.settingVerbosity = false
+	lp.onVerbosityScopeChanged()
+}
+
+// showVerbosity shows the levels in effect, and the level of the selected
+// component or tunnel, if any is set.
+func (lp *LogPage) showVerbosity(verbosity *ringlogger.Verbosity) {
+	lp.verbosity = verbosity
+	lp.verbosityLabel.SetText(l18n.Sprintf("Log verbosity: %v", verbosity))
+	if lp.verbosityScope != nil {
+		lp.onVerbosityScopeChanged()
+	}
+}
+
+func (lp *LogPage) onVerbosityScopeChanged() {
+	if lp.settingVerbosity {
+		return
+	}
+	index := lp.verbosityScope.CurrentIndex()
+	if index < 0 || index >= len(lp.verbosityScopes) {
+		return
+	}
+	scope := lp.verbosityScopes[index]
+	var level ringlogger.Level
+	if lp.verbosity != nil {
+		if len(scope.tag) > 0 {
+			level = lp.verbosity.Components[scope.tag]
+		} else {
+			level = lp.verbosity.Tunnels[scope.tunnel]
+		}
+	}
+	lp.settingVerbosity = true
+	lp.verbosityLevel.SetCurrentIndex(int(level))
+	lp.settingVerbosity = false
+}
+
+func (lp *LogPage) onVerbosityLevelChanged() {
+	if lp.settingVerbosity {
+		return
+	}
+	index := lp.verbosityScope.CurrentIndex()
+	level := lp.verbosityLevel.CurrentIndex()
+	if index < 0 || index >= len(lp.verbosityScopes) || level < 0 {
+		return
+	}
+	lp.setVerbosity(lp.verbosityScopes[index], ringlogger.Level(level))
+}
+
+func (lp *LogPage) setVerbosity(scope verbosityScope, level ringlogger.Level) {
+	err := manager.IPCClientSetLogVerbosity(scope.tag, scope.tunnel, level)
+	if err != nil {
+		showErrorCustom(lp.Form(), l18n.Sprintf("Unable to set log verbosity"), err.Error())
+	}
+}
+
 func (lp *LogPage) isAtBottom() bool {
 	return len(lp.model.items) == 0 || lp.logView.ItemVisible(len(lp.model.items)-1)
 }
@@ -134,13 +330,27 @@ func (lp *LogPage) onCopy() {
 	if len(selectedItemIndexes) == 0 {
 		return
 	}
+	redactor := lp.redactor()
 	for i := 0; i < len(selectedItemIndexes); i++ {
-		logItem := lp.model.items[selectedItemIndexes[i]]
+		logItem := redactor.RedactLine(lp.model.items[selectedItemIndexes[i]].FollowLine)
 		logLines.WriteString(fmt.Sprintf("%s: %s\r\n", logItem.Stamp.Format("2006-01-02 15:04:05.000"), logItem.Line))
 	}
 	walk.Clipboard().SetText(logLines.String())
 }
 
+func (lp *LogPage) redactionLevel() ringlogger.RedactionLevel {
+	if index := lp.redaction.CurrentIndex(); index >= 0 {
+		return ringlogger.RedactionLevel(index)
+	}
+	return ringlogger.RedactKeys
+}
+
+// redactor returns a new redactor for a single export, so that pseudonyms do
+// not carry over between exports.
+func (lp *LogPage) redactor() *ringlogger.Redactor {
+	return ringlogger.NewRedactor(lp.redactionLevel())
+}
+
 func (lp *LogPage) onSelectAll() {
 	lp.logView.SetSelectedIndexes([]int{-1})
 }
@@ -163,19 +373,54 @@ func (lp *LogPage) onSave() {
 	}
 
 	writeFileWithOverwriteHandling(form, fd.FilePath, func(file *os.File) error {
-		if _, err := ringlogger.Global.WriteTo(file); err != nil {
-			return fmt.Errorf("exportLog: Ringlogger.WriteTo failed: %w", err)
+		if _, err := ringlogger.Global.WriteRedactedTo(file, lp.redactor()); err != nil {
+			return fmt.Errorf("exportLog: Ringlogger.WriteRedactedTo failed: %w", err)
 		}
 
 		return nil
 	})
 }
 
+func (lp *LogPage) onSaveDiagnostics() {
+	fd := walk.FileDialog{
+		Filter:   l18n.Sprintf("ZIP Files (*.zip)|*.zip|All Files (*.*)|*.*"),
+		FilePath: fmt.Sprintf("amneziawg-diagnostics-%s.zip", time.Now().Format("2006-01-02T150405")),
+		Title:    l18n.Sprintf("Export diagnostics to file"),
+	}
+
+	form := lp.Form()
+
+	if ok, _ := fd.ShowSave(form); !ok {
+		return
+	}
+
+	if fd.FilterIndex == 1 && !strings.HasSuffix(fd.FilePath, ".zip") {
+		fd.FilePath = fd.FilePath + ".zip"
+	}
+
+	redaction := lp.redactionLevel()
+	writeFileWithOverwriteHandling(form, fd.FilePath, func(file *os.File) error {
+		diagnostics, err := manager.IPCClientDiagnostics(redaction)
+		if err != nil {
+			return fmt.Errorf("exportDiagnostics: IPCClientDiagnostics failed: %w", err)
+		}
+		_, err = file.Write(diagnostics)
+		return err
+	})
+}
+
+// logItem shows a message spanning several lines on a single row, while
+// copying it still copies all of its lines.
+type logItem struct {
+	ringlogger.FollowLine
+	Summary string
+}
+
 type logModel struct {
 	walk.ReflectTableModelBase
 	lp    *LogPage
 	quit  chan bool
-	items []ringlogger.FollowLine
+	items []logItem
 }
 
 func newLogModel(lp *LogPage) *logModel {
@@ -183,19 +428,38 @@ func newLogModel(lp *LogPage) *logModel {
 	go func() {
 		ticker := time.NewTicker(time.Second)
 		cursor := ringlogger.CursorAll
+		loadedArchive := false
 
 		for {
 			select {
 			case <-ticker.C:
 				var items []ringlogger.FollowLine
-				items, cursor = ringlogger.Global.FollowFromCursor(cursor)
+				var missed uint64
+				items, cursor, missed = ringlogger.Global.FollowFromCursor(cursor)
+				if missed > 0 {
+					items = append([]ringlogger.FollowLine{ringlogger.MissedLine(missed)}, items...)
+				}
+				if !loadedArchive {
+					loadedArchive = true
+					before := time.Now()
+					if len(items) > 0 {
+						before = items[0].Stamp
+					}
+					if limit := maxLogLinesDisplayed - len(items); limit > 0 {
+						if archived, err := manager.IPCClientLogArchive(before, limit); err == nil {
+							items = append(archived, items...)
+						}
+					}
+				}
 				if len(items) == 0 {
 					continue
 				}
 				mdl.lp.Synchronize(func() {
 					isAtBottom := mdl.lp.isAtBottom() && len(lp.logView.SelectedIndexes()) <= 1
 
-					mdl.items = append(mdl.items, items...)
+					for _, item := range items {
+						mdl.items = append(mdl.items, logItem{item, strings.ReplaceAll(strings.ReplaceAll(item.Line, "\r\n", "\n"), "\n", " ⏎ ")})
+					}
 					if len(mdl.items) > maxLogLinesDisplayed {
 						mdl.items = mdl.items[len(mdl.items)-maxLogLinesDisplayed:]
 					}
@@ -25,6 +25,8 @@ type ManageTunnelsWindow struct {
 	logPage     *LogPage
 	updatePage  *UpdatePage
 
+	titleBeforeUpdate string
+
 	tunnelChangedCB *manager.TunnelChangeCallback
 }
 
@@ -180,6 +182,7 @@ func (mtw *ManageTunnelsWindow) UpdateFound() {
 		return
 	}
 	if IsAdmin {
+		mtw.titleBeforeUpdate = mtw.Title()
 		mtw.SetTitle(l18n.Sprintf("%s (out of date)", mtw.Title()))
 	}
 	updatePage, err := NewUpdatePage()
@@ -189,6 +192,20 @@ func (mtw *ManageTunnelsWindow) UpdateFound() {
 	}
 }
 
+// UpdateNotFound undoes UpdateFound, once switching the update channel left
+// nothing newer to update to.
+func (mtw *ManageTunnelsWindow) UpdateNotFound() {
+	if mtw.updatePage == nil {
+		return
+	}
+	if IsAdmin {
+		mtw.SetTitle(mtw.titleBeforeUpdate)
+	}
+	mtw.tabs.Pages().Remove(mtw.updatePage.TabPage)
+	mtw.updatePage.Dispose()
+	mtw.updatePage = nil
+}
+
 func (mtw *ManageTunnelsWindow) WndProc(hwnd win.HWND, msg uint32, wParam, lParam uintptr) uintptr {
 	switch msg {
 	case win.WM_QUERYENDSESSION:
@@ -12,6 +12,7 @@ import (
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/l18n"
 	"github.com/amnezia-vpn/amneziawg-windows-client/manager"
+	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
 	"github.com/amnezia-vpn/amneziawg-windows/conf"
 
 	"github.com/lxn/walk"
@@ -32,7 +33,9 @@ type Tray struct {
 	tunnelChangedCB  *manager.TunnelChangeCallback
 	tunnelsChangedCB *manager.TunnelsChangeCallback
 
-	clicked func()
+	clicked       func()
+	updateAction  *walk.Action
+	updateVersion string // Version of the update announced, if its release notes gave it.
 }
 
 func NewTray(mtw *ManageTunnelsWindow) (*Tray, error) {
@@ -340,7 +343,15 @@ func (tray *Tray) setTunnelState(tunnel *manager.Tunnel, state manager.TunnelSta
 	}
 }
 
-func (tray *Tray) UpdateFound() {
+func (tray *Tray) UpdateFound(notes updater.ReleaseNotes) {
+	if tray.updateAction != nil {
+		if notes.Version == tray.updateVersion {
+			return
+		}
+		// A newer update was published since, which may fix security issues.
+		tray.showUpdateBalloon(notes)
+		return
+	}
 	action := walk.NewAction()
 	action.SetText(l18n.Sprintf("An Update is Available!"))
 	menuIcon, _ := loadShieldIcon(16)
@@ -356,10 +367,22 @@ func (tray *Tray) UpdateFound() {
 	action.Triggered().Attach(showUpdateTab)
 	tray.clicked = showUpdateTab
 	tray.ContextMenu().Actions().Insert(tray.ContextMenu().Actions().Len()-2, action)
+	tray.updateAction = action
+	tray.showUpdateBalloon(notes)
+}
 
+func (tray *Tray) showUpdateBalloon(notes updater.ReleaseNotes) {
+	tray.updateVersion = notes.Version
 	showUpdateBalloon := func() {
 		icon, _ := loadShieldIcon(128)
-		tray.ShowCustom(l18n.Sprintf("AmneziaWG Update Available"), l18n.Sprintf("An update to AmneziaWG is now available. You are advised to update as soon as possible."), icon)
+		switch {
+		case notes.Severity == updater.SeveritySecurity:
+			tray.ShowCustom(l18n.Sprintf("AmneziaWG Security Update Available"), l18n.Sprintf("A security update to AmneziaWG %s is now available. Please update without delay.", notes.Version), icon)
+		case len(notes.Version) > 0:
+			tray.ShowCustom(l18n.Sprintf("AmneziaWG Update Available"), l18n.Sprintf("An update to AmneziaWG %s is now available. You are advised to update as soon as possible.", notes.Version), icon)
+		default:
+			tray.ShowCustom(l18n.Sprintf("AmneziaWG Update Available"), l18n.Sprintf("An update to AmneziaWG is now available. You are advised to update as soon as possible."), icon)
+		}
 	}
 
 	timeSinceStart := time.Now().Sub(startTime)
@@ -372,6 +395,59 @@ func (tray *Tray) UpdateFound() {
 	}
 }
 
+// UpdateNotFound undoes UpdateFound, once switching the update channel left
+// nothing newer to update to.
+func (tray *Tray) UpdateNotFound() {
+	if tray.updateAction == nil {
+		return
+	}
+	tray.ContextMenu().Actions().Remove(tray.updateAction)
+	tray.updateAction = nil
+	tray.updateVersion = ""
+	tray.clicked = tray.onManageTunnels
+}
+
+// UpdateFailed warns that installing an update failed, which, unless the
+// version it would have replaced was reinstalled, may have left AmneziaWG
+// broken.
+func (tray *Tray) UpdateFailed(rolledBack bool) {
+	showFailureBalloon := func() {
+		if rolledBack {
+			tray.ShowWarning(l18n.Sprintf("AmneziaWG Update Failed"), l18n.Sprintf("Installing the update to AmneziaWG failed, so the previous version was reinstalled."))
+		} else {
+			tray.ShowWarning(l18n.Sprintf("AmneziaWG Update Failed"), l18n.Sprintf("Installing the update to AmneziaWG failed, and the previous version could not be reinstalled. Please reinstall AmneziaWG."))
+		}
+	}
+
+	timeSinceStart := time.Now().Sub(startTime)
+	if timeSinceStart < time.Second*3 {
+		time.AfterFunc(time.Second*3-timeSinceStart, func() {
+			tray.mtw.Synchronize(showFailureBalloon)
+		})
+	} else {
+		showFailureBalloon()
+	}
+}
+
+func crashMessage(crashes []string) string {
+	return l18n.Sprintf("A crash occurred since AmneziaWG last started. Details were saved next to the log in %s.", strings.Join(crashes, ", "))
+}
+
+func (tray *Tray) CrashOccurred(crashes []string) {
+	showCrashBalloon := func() {
+		tray.ShowWarning(l18n.Sprintf("AmneziaWG Crashed"), crashMessage(crashes))
+	}
+
+	timeSinceStart := time.Now().Sub(startTime)
+	if timeSinceStart < time.Second*3 {
+		time.AfterFunc(time.Second*3-timeSinceStart, func() {
+			tray.mtw.Synchronize(showCrashBalloon)
+		})
+	} else {
+		showCrashBalloon()
+	}
+}
+
 func (tray *Tray) onManageTunnels() {
 	tray.mtw.tunnelsPage.listView.SelectFirstActiveTunnel()
 	tray.mtw.tabs.SetCurrentIndex(0)
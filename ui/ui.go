@@ -17,6 +17,7 @@ import (
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/l18n"
 	"github.com/amnezia-vpn/amneziawg-windows-client/manager"
+	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
 	"github.com/amnezia-vpn/amneziawg-windows-client/version"
 )
 
@@ -67,28 +68,61 @@ func RunUI() {
 		})
 	})
 
-	onUpdateNotification := func(updateState manager.UpdateState) {
-		if updateState == manager.UpdateStateUnknown {
-			return
-		}
+	onUpdateNotification := func(updateState manager.UpdateState, notes updater.ReleaseNotes) {
 		mtw.Synchronize(func() {
 			switch updateState {
-			case manager.UpdateStateFoundUpdate:
+			case manager.UpdateStateUnknown:
+				mtw.UpdateNotFound()
+				if tray != nil {
+					tray.UpdateNotFound()
+				}
+			case manager.UpdateStateFoundUpdate, manager.UpdateStateDownloadedUpdate, manager.UpdateStateInstallingUpdate, manager.UpdateStateRolledBackUpdate, manager.UpdateStateFailedUpdate:
 				mtw.UpdateFound()
+				if mtw.updatePage != nil {
+					mtw.updatePage.SetReleaseNotes(notes)
+				}
 				if tray != nil && IsAdmin {
-					tray.UpdateFound()
+					tray.UpdateFound(notes)
+				}
+				if updateState == manager.UpdateStateDownloadedUpdate && mtw.updatePage != nil {
+					mtw.updatePage.UpdateDownloaded()
+				}
+				if updateState == manager.UpdateStateRolledBackUpdate || updateState == manager.UpdateStateFailedUpdate {
+					rolledBack := updateState == manager.UpdateStateRolledBackUpdate
+					if mtw.updatePage != nil {
+						mtw.updatePage.UpdateFailed(rolledBack)
+					}
+					if tray != nil && IsAdmin {
+						tray.UpdateFailed(rolledBack)
+					}
 				}
 			case manager.UpdateStateUpdatesDisabledUnofficialBuild:
 				mtw.SetTitle(l18n.Sprintf("%s (unsigned build, no updates)", mtw.Title()))
+			case manager.UpdateStateUpdatesDisabledByPolicy:
+				mtw.SetTitle(l18n.Sprintf("%s (updates disabled by administrator)", mtw.Title()))
 			}
 		})
 	}
 	manager.IPCClientRegisterUpdateFound(onUpdateNotification)
 	go func() {
-		updateState, err := manager.IPCClientUpdateState()
+		updateState, notes, err := manager.IPCClientUpdateState()
 		if err == nil {
-			onUpdateNotification(updateState)
+			onUpdateNotification(updateState, notes)
+		}
+	}()
+
+	go func() {
+		crashes, err := manager.IPCClientCrashes()
+		if err != nil || len(crashes) == 0 {
+			return
 		}
+		mtw.Synchronize(func() {
+			if tray != nil {
+				tray.CrashOccurred(crashes)
+			} else {
+				showWarningCustom(mtw, l18n.Sprintf("AmneziaWG Crashed"), crashMessage(crashes))
+			}
+		})
 	}()
 
 	if tray == nil {
@@ -6,15 +6,37 @@
 package ui
 
 import (
+	"strings"
+
 	"github.com/lxn/walk"
+	"github.com/lxn/win"
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/l18n"
 	"github.com/amnezia-vpn/amneziawg-windows-client/manager"
 	"github.com/amnezia-vpn/amneziawg-windows-client/updater"
+	"github.com/amnezia-vpn/amneziawg-windows/conf"
 )
 
 type UpdatePage struct {
 	*walk.TabPage
+	instructions *walk.TextLabel
+	release      *walk.TextLabel
+	changelog    *walk.TextEdit
+	status       *walk.TextLabel
+	bar          *walk.ProgressBar
+}
+
+func channelName(channel updater.Channel) string {
+	switch channel {
+	case updater.ChannelStable:
+		return l18n.Sprintf("Stable")
+	case updater.ChannelBeta:
+		return l18n.Sprintf("Beta")
+	case updater.ChannelNightly:
+		return l18n.Sprintf("Nightly")
+	default:
+		return string(channel)
+	}
 }
 
 func NewUpdatePage() (*UpdatePage, error) {
@@ -42,6 +64,36 @@ func NewUpdatePage() (*UpdatePage, error) {
 	}
 	instructions.SetText(l18n.Sprintf("An update to AmneziaWG is available. It is highly advisable to update without delay."))
 	instructions.SetMinMaxSize(walk.Size{1, 0}, walk.Size{0, 0})
+	up.instructions = instructions
+
+	release, err := walk.NewTextLabel(up)
+	if err != nil {
+		return nil, err
+	}
+	release.SetMinMaxSize(walk.Size{1, 0}, walk.Size{0, 0})
+	release.SetVisible(false)
+	up.release = release
+
+	changelog, err := walk.NewTextEditWithStyle(up, win.WS_VSCROLL)
+	if err != nil {
+		return nil, err
+	}
+	changelog.SetReadOnly(true)
+	changelog.SetVisible(false)
+	up.changelog = changelog
+
+	if channel, byAdmin, err := manager.IPCClientUpdateChannel(); err == nil {
+		channelLbl, err := walk.NewTextLabel(up)
+		if err != nil {
+			return nil, err
+		}
+		if byAdmin {
+			channelLbl.SetText(l18n.Sprintf("Channel: %s (set by the administrator)", channelName(channel)))
+		} else {
+			channelLbl.SetText(l18n.Sprintf("Channel: %s", channelName(channel)))
+		}
+		channelLbl.SetMinMaxSize(walk.Size{1, 0}, walk.Size{0, 0})
+	}
 
 	status, err := walk.NewTextLabel(up)
 	if err != nil {
@@ -49,12 +101,14 @@ func NewUpdatePage() (*UpdatePage, error) {
 	}
 	status.SetText(l18n.Sprintf("Status: Waiting for user"))
 	status.SetMinMaxSize(walk.Size{1, 0}, walk.Size{0, 0})
+	up.status = status
 
 	bar, err := walk.NewProgressBar(up)
 	if err != nil {
 		return nil, err
 	}
 	bar.SetVisible(false)
+	up.bar = bar
 
 	button, err := walk.NewPushButton(up)
 	if err != nil {
@@ -106,6 +160,8 @@ func NewUpdatePage() (*UpdatePage, error) {
 		}
 	})
 
+	// activity is the step of updating that progress without one is about.
+	var activity string
 	manager.IPCClientRegisterUpdateProgress(func(dp updater.DownloadProgress) {
 		up.Synchronize(func() {
 			switchToUpdatingState()
@@ -116,8 +172,12 @@ func NewUpdatePage() (*UpdatePage, error) {
 				return
 			}
 			if len(dp.Activity) > 0 {
-				stateText := dp.Activity
-				status.SetText(l18n.Sprintf("Status: %s", stateText))
+				activity = dp.Activity
+				status.SetText(l18n.Sprintf("Status: %s", activity))
+			} else if dp.BytesPerSecond > 0 && dp.Remaining > 0 {
+				status.SetText(l18n.Sprintf("Status: %s (%s/s, %v remaining)", activity, conf.Bytes(dp.BytesPerSecond).String(), dp.Remaining))
+			} else if dp.BytesPerSecond > 0 {
+				status.SetText(l18n.Sprintf("Status: %s (%s/s)", activity, conf.Bytes(dp.BytesPerSecond).String()))
 			}
 			if dp.BytesTotal > 0 {
 				bar.SetMarqueeMode(false)
@@ -140,3 +200,57 @@ func NewUpdatePage() (*UpdatePage, error) {
 
 	return up, nil
 }
+
+func severityName(severity updater.Severity) string {
+	switch severity {
+	case updater.SeveritySecurity:
+		return l18n.Sprintf("Security")
+	case updater.SeverityImportant:
+		return l18n.Sprintf("Important")
+	default:
+		return l18n.Sprintf("Routine")
+	}
+}
+
+// SetReleaseNotes shows what changed in the update, and whether it fixes
+// security issues, or hides the notes if it has none.
+func (up *UpdatePage) SetReleaseNotes(notes updater.ReleaseNotes) {
+	up.SetSuspended(true)
+	defer up.SetSuspended(false)
+	if len(notes.Version) == 0 {
+		up.release.SetVisible(false)
+		up.changelog.SetVisible(false)
+		return
+	}
+	if notes.Severity == updater.SeveritySecurity {
+		up.instructions.SetText(l18n.Sprintf("A security update to AmneziaWG is available. Please update without delay."))
+	} else {
+		up.instructions.SetText(l18n.Sprintf("An update to AmneziaWG is available. It is highly advisable to update without delay."))
+	}
+	up.release.SetText(l18n.Sprintf("Version: %s, released %s (%s)", notes.Version, notes.Date.Format("2006-01-02"), severityName(notes.Severity)))
+	up.release.SetVisible(true)
+	up.changelog.SetText(strings.ReplaceAll(notes.Changelog, "\n", "\r\n"))
+	up.changelog.SetVisible(len(notes.Changelog) > 0)
+}
+
+// UpdateDownloaded notes that the update was downloaded in the background, as
+// the admin update policy asks, so that updating need not wait for it.
+func (up *UpdatePage) UpdateDownloaded() {
+	if !up.bar.Visible() {
+		up.status.SetText(l18n.Sprintf("Status: Downloaded and ready to install"))
+	}
+}
+
+// UpdateFailed notes that installing the update failed before, and whether
+// the version it would have replaced was reinstalled, so that it is not
+// installed automatically again.
+func (up *UpdatePage) UpdateFailed(rolledBack bool) {
+	if up.bar.Visible() {
+		return
+	}
+	if rolledBack {
+		up.status.SetText(l18n.Sprintf("Status: Installing the update failed, so the previous version was reinstalled"))
+	} else {
+		up.status.SetText(l18n.Sprintf("Status: Installing the update failed, and the previous version could not be reinstalled"))
+	}
+}
@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+)
+
+// Channel is a series of releases, each with its own signed list.
+type Channel string
+
+const (
+	ChannelStable  Channel = "stable"
+	ChannelBeta    Channel = "beta"
+	ChannelNightly Channel = "nightly"
+)
+
+// Channels lists the channels from the most to the least stable.
+var Channels = []Channel{ChannelStable, ChannelBeta, ChannelNightly}
+
+func ParseChannel(s string) (Channel, error) {
+	for _, channel := range Channels {
+		if strings.EqualFold(s, string(channel)) {
+			return channel, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown update channel %#q", s)
+}
+
+// CurrentChannel returns the channel updates are taken from, which is the one
+// set by the admin, if any, and then the one chosen with SetChannel,
+// defaulting to stable, and whether it was set by the admin.
+func CurrentChannel() (channel Channel, byAdmin bool, err error) {
+	if s := services.AdminKeyString(updateChannelAdminKey); len(s) > 0 {
+		channel, err = ParseChannel(s)
+		if err != nil {
+			return "", true, fmt.Errorf("Invalid %s admin registry value: %w", updateChannelAdminKey, err)
+		}
+		return channel, true, nil
+	}
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return ChannelStable, false, err
+	}
+	if channel, err = ParseChannel(state.Channel); err != nil {
+		return ChannelStable, false, nil
+	}
+	return channel, false, nil
+}
+
+// SetChannel chooses the channel updates are taken from, unless the admin has
+// set it. Switching to a more stable channel never downgrades, but rather
+// waits for that channel to have a release newer than the one installed.
+func SetChannel(channel Channel) error {
+	if len(services.AdminKeyString(updateChannelAdminKey)) > 0 {
+		return errors.New("The update channel is set by the administrator")
+	}
+	if _, err := ParseChannel(string(channel)); err != nil {
+		return err
+	}
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return err
+	}
+	state.Channel = string(channel)
+	return state.persist()
+}
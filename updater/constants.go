@@ -6,12 +6,24 @@
 package updater
 
 const (
-	releasePublicKeyBase64 = "RWRNqGKtBXftKTKPpBPGDMe8jHLnFQ0EdRy8Wg0apV6vTDFLAODD83G4"
-	updateServerHost       = "download.wireguard.com"
-	updateServerPort       = 443
-	updateServerUseHttps   = true
-	latestVersionPath      = "/windows-client/latest.sig"
-	msiPath                = "/windows-client/%s"
-	msiArchPrefix          = "wireguard-%s-"
-	msiSuffix              = ".msi"
+	releasePublicKeyBase64      = "RWQ9D4bS9C2kf4o+lSU4nJXrVPaUQRa/XGTOpzGwz3/or3+MnFQvWe88"
+	updateServerHost            = "github.com"
+	updateServerPort            = 443
+	updateServerUseHttps        = true
+	releasesPath                = "/amnezia-vpn/amneziawg-windows-client/releases/"
+	listName                    = "latest.sig"
+	keysName                    = "keys.sig"
+	msiArchPrefix               = "amneziawg-%s-"
+	msiSuffix                   = ".msi"
+	releaseNotesPrefix          = "amneziawg-notes-"
+	releaseNotesSuffix          = ".json"
+	updateServerAdminKey        = "UpdateServer"
+	updateFolderAdminKey        = "UpdateFolder"
+	updatePublicKeysAdminKey    = "UpdatePublicKeys"
+	updateChannelAdminKey       = "UpdateChannel"
+	updatePolicyAdminKey        = "UpdatePolicy"
+	maintenanceWindowAdminKey   = "UpdateMaintenanceWindow"
+	updateProxyAdminKey         = "UpdateProxy"
+	updateProxyUsernameAdminKey = "UpdateProxyUsername"
+	updateProxyPasswordAdminKey = "UpdateProxyPassword"
 )
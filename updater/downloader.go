@@ -9,98 +9,364 @@ import (
 	"crypto/hmac"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
+	"os"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/crypto/blake2b"
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/elevate"
-	"github.com/amnezia-vpn/amneziawg-windows-client/updater/winhttp"
 	"github.com/amnezia-vpn/amneziawg-windows-client/version"
 )
 
+// DownloadProgress reports a step of updating when Activity is set, and
+// otherwise only how far downloading has come, at most every
+// progressInterval.
 type DownloadProgress struct {
 	Activity        string
 	BytesDownloaded uint64
 	BytesTotal      uint64
 	Error           error
 	Complete        bool
+	BytesPerSecond  uint64        // Smoothed throughput, or zero if not yet known.
+	Remaining       time.Duration // Estimated time left, or zero if not known.
 }
 
 type progressHashWatcher struct {
-	dp        *DownloadProgress
-	c         chan DownloadProgress
-	hashState hash.Hash
+	dp    *DownloadProgress
+	c     chan DownloadProgress
+	dl    *download
+	meter throughputMeter
+}
+
+func newProgressHashWatcher(dp *DownloadProgress, c chan DownloadProgress, dl *download) *progressHashWatcher {
+	return &progressHashWatcher{dp: dp, c: c, dl: dl, meter: newThroughputMeter(time.Now(), dl.length)}
 }
 
 func (pm *progressHashWatcher) Write(p []byte) (int, error) {
-	bytes := len(p)
-	pm.dp.BytesDownloaded += uint64(bytes)
-	pm.c <- *pm.dp
-	pm.hashState.Write(p)
-	return bytes, nil
+	bytes, err := pm.dl.Write(p)
+	pm.dp.BytesDownloaded = pm.dl.length
+	if pm.meter.sample(time.Now(), pm.dl.length) || pm.dl.length == pm.dp.BytesTotal {
+		pm.c <- DownloadProgress{
+			BytesDownloaded: pm.dp.BytesDownloaded,
+			BytesTotal:      pm.dp.BytesTotal,
+			BytesPerSecond:  pm.meter.bytesPerSecond(),
+			Remaining:       pm.meter.remaining(pm.dp.BytesDownloaded, pm.dp.BytesTotal),
+		}
+	}
+	return bytes, err
 }
 
 type UpdateFound struct {
-	name string
-	hash [blake2b.Size256]byte
+	name    string
+	hash    [blake2b.Size256]byte
+	channel Channel
+	version version.Version
+	notes   *ReleaseNotes
+	// notesErr is why there are no notes, though the signed list has some.
+	notesErr error
+}
+
+// Version returns the version of the update.
+func (update *UpdateFound) Version() version.Version {
+	return update.version
+}
+
+// Notes returns the release notes of the update, which are nil if the signed
+// list has none, or if they could not be read or verified, which the update
+// is still trusted despite, since the MSI is verified on its own.
+func (update *UpdateFound) Notes() (*ReleaseNotes, error) {
+	return update.notes, update.notesErr
 }
 
 func CheckForUpdate() (updateFound *UpdateFound, err error) {
-	updateFound, _, _, err = checkForUpdate(false)
+	updateFound, _, _, err = checkForUpdate(nil, false)
 	return
 }
 
-func checkForUpdate(keepSession bool) (*UpdateFound, *winhttp.Session, *winhttp.Connection, error) {
+// checkForUpdate looks for an update at source, or at the current source if it
+// is nil.
+func checkForUpdate(source *Source, keepTransport bool) (*UpdateFound, *Source, transport, error) {
 	if !version.IsRunningOfficialVersion() {
 		return nil, nil, nil, errors.New("Build is not official, so updates are disabled")
 	}
-	session, err := winhttp.NewSession(version.UserAgent())
+	var err error
+	if source == nil {
+		source, err = CurrentSource()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	channel, _, err := CurrentChannel()
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	defer func() {
-		if err != nil || !keepSession {
-			session.Close()
-		}
-	}()
-	connection, err := session.Connect(updateServerHost, updateServerPort, updateServerUseHttps)
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	defer func() {
-		if err != nil || !keepSession {
-			connection.Close()
-		}
-	}()
-	response, err := connection.Get(latestVersionPath, true)
+	updateFound, t, err := checkSourceForUpdate(source, channel, state, keepTransport)
+	return updateFound, source, t, err
+}
+
+// fetch reads the file at path, of at most 512 KiB, or returns nil if it is
+// optional and the server does not have it.
+func fetch(t transport, path string, optional bool) ([]byte, error) {
+	response, err := t.Get(path, true)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 	defer response.Close()
-	var fileList [1024 * 512] /* 512 KiB */ byte
-	bytesRead, err := response.Read(fileList[:])
-	if err != nil && (err != io.EOF || bytesRead == 0) {
-		return nil, nil, nil, err
+	status, err := response.StatusCode()
+	if err != nil {
+		return nil, err
 	}
-	files, err := readFileList(fileList[:bytesRead])
+	if status == 404 && optional {
+		return nil, nil
+	} else if status != 200 {
+		return nil, fmt.Errorf("Update server returned HTTP status %d for %#q", status, path)
+	}
+	return io.ReadAll(io.LimitReader(response, 1024*512 /* 512 KiB */))
+}
+
+// readSourceFile reads the file at path of source, through t unless source is
+// a folder.
+func readSourceFile(source *Source, t transport, path string, optional bool) ([]byte, error) {
+	if len(source.Folder) > 0 {
+		return readFolderFile(path, optional)
+	}
+	return fetch(t, path, optional)
+}
+
+// checkSourceForUpdate looks for an update in channel at source, trusting the keys of
+// source and those learned from it before, as remembered by state, which it
+// updates and saves if it learns about keys or sees a newer list.
+func checkSourceForUpdate(source *Source, channel Channel, state *updaterState, keepTransport bool) (updateFound *UpdateFound, t transport, err error) {
+	kr, err := state.keyring(source)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
+	}
+	now := time.Now()
+	if len(source.Folder) > 0 {
+		// Lists carried to sites without a server may well outlive their
+		// expiry, which could not keep such sites from being held back anyway.
+		now = time.Time{}
+	} else {
+		// Kept apart from t, which returning sets to nil before this closes it.
+		var dialed transport
+		dialed, err = dialTransport(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			if err != nil || !keepTransport {
+				dialed.Close()
+			}
+		}()
+		t = dialed
 	}
-	updateFound, err := findCandidate(files)
+	statements, err := readSourceFile(source, t, source.KeysPath, true)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
+	}
+	err = kr.applyStatements(statements)
+	if err != nil {
+		return nil, nil, err
 	}
-	if keepSession {
-		return updateFound, session, connection, nil
+	if kr.changed {
+		state.learn(kr)
+		err = state.persist()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	list, err := readSourceFile(source, t, source.listPath(channel), false)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, header, err := readFileList(list, kr)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := header.checkFresh(now, state.Serials[channel])
+	if err != nil {
+		return nil, nil, err
+	}
+	if serial != state.Serials[channel] {
+		if state.Serials == nil {
+			state.Serials = make(map[Channel]uint64)
+		}
+		state.Serials[channel] = serial
+		err = state.persist()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	updateFound, err = findCandidate(files, source, channel)
+	if err != nil {
+		return nil, nil, err
 	}
-	return updateFound, nil, nil, nil
+	if updateFound != nil {
+		updateFound.notes, updateFound.notesErr = readReleaseNotes(source, t, channel, files, updateFound)
+	}
+	if keepTransport {
+		return updateFound, t, nil
+	}
+	return updateFound, nil, nil
 }
 
 var updateInProgress = uint32(0)
 
+// preparedUpdate is an update that PrepareUpdate has downloaded and verified,
+// waiting to be installed, and is only touched while updateInProgress is set.
+var preparedUpdate struct {
+	update *UpdateFound
+	file   *tempFile
+}
+
+// verifyFile checks that a downloaded update still has the expected hash and
+// an authentic authenticode signature.
+func verifyFile(file *tempFile, update *UpdateFound) error {
+	f, err := os.Open(file.ExclusivePath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher, err := blake2b.New256(nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(hasher, io.LimitReader(f, maxMsiSize+1))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(hasher.Sum(nil), update.hash[:]) {
+		return errors.New("The downloaded update has the wrong hash")
+	}
+	if !verifyAuthenticode(file.ExclusivePath()) {
+		return errors.New("The downloaded update does not have an authentic authenticode signature")
+	}
+	return nil
+}
+
+// takePreparedUpdate returns the update prepared earlier, if it is still
+// intact and the same as update, and otherwise discards it.
+func takePreparedUpdate(update *UpdateFound) *tempFile {
+	file := preparedUpdate.file
+	prepared := preparedUpdate.update
+	preparedUpdate.update, preparedUpdate.file = nil, nil
+	if file == nil {
+		return nil
+	}
+	if prepared.name != update.name || prepared.hash != update.hash || verifyFile(file, update) != nil {
+		file.Delete()
+		return nil
+	}
+	return file
+}
+
+// downloadAndVerify finds the latest update at source, or at the current
+// source if it is nil, and downloads and verifies it, unless it was already
+// prepared.
+func downloadAndVerify(source *Source, progress chan DownloadProgress) (*UpdateFound, *tempFile, error) {
+	progress <- DownloadProgress{Activity: "Checking for update"}
+	update, source, t, err := checkForUpdate(source, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != nil {
+		defer t.Close()
+	}
+	if update == nil {
+		return nil, nil, errors.New("No update was found")
+	}
+	progress <- DownloadProgress{Activity: fmt.Sprintf("Found version %v in the %s channel", update.version, update.channel)}
+
+	if file := takePreparedUpdate(update); file != nil {
+		progress <- DownloadProgress{Activity: "Using the update downloaded before"}
+		return update, file, nil
+	}
+
+	file, dl, err := openDownload(update, progress)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(source.Folder) > 0 {
+		err = dl.copyFrom(source.msiPath(update.channel, update.name), progress)
+	} else {
+		err = dl.fetch(t, source.msiPath(update.channel, update.name), progress)
+	}
+	if err != nil {
+		if len(source.Folder) == 0 && dl.length > 0 && savePartialDownload(dl, update) == nil {
+			file.Close()
+		} else {
+			file.Delete()
+		}
+		return nil, nil, err
+	}
+	if !dl.matches(update) {
+		file.Delete()
+		return nil, nil, errors.New("The downloaded update has the wrong hash")
+	}
+
+	// The hash of a resumed download covers bytes written by an earlier
+	// attempt, which may have changed since, so the whole file is hashed again.
+	progress <- DownloadProgress{Activity: "Verifying hash and authenticode signature"}
+	err = verifyFile(file, update)
+	if err != nil {
+		file.Delete()
+		return nil, nil, err
+	}
+	return update, file, nil
+}
+
+// PrepareUpdate downloads and verifies the latest update without installing
+// it, so that a later DownloadVerifyAndExecute need not wait for the download.
+// It must be called as SYSTEM.
+func PrepareUpdate() (*UpdateFound, error) {
+	if !atomic.CompareAndSwapUint32(&updateInProgress, 0, 1) {
+		return nil, errors.New("An update is already in progress")
+	}
+	defer atomic.StoreUint32(&updateInProgress, 0)
+
+	progress := make(chan DownloadProgress, 128)
+	defer close(progress)
+	go func() {
+		for range progress {
+		}
+	}()
+	update, file, err := downloadAndVerify(nil, progress)
+	if err != nil {
+		return nil, err
+	}
+	preparedUpdate.update, preparedUpdate.file = update, file
+	return update, nil
+}
+
 func DownloadVerifyAndExecute(userToken uintptr) (progress chan DownloadProgress) {
+	return downloadVerifyAndExecute(nil, userToken)
+}
+
+// DownloadVerifyAndExecuteFrom is like DownloadVerifyAndExecute, but takes the
+// update from folder, an absolute path laid out like a mirror, rather than
+// from the current source.
+func DownloadVerifyAndExecuteFrom(folder string, userToken uintptr) (progress chan DownloadProgress) {
+	source, err := DefaultSource.WithFolder(folder)
+	if err == nil {
+		source.PublicKeys, err = CurrentPublicKeys()
+	}
+	if err != nil {
+		progress = make(chan DownloadProgress, 1)
+		progress <- DownloadProgress{Error: err}
+		return
+	}
+	return downloadVerifyAndExecute(source, userToken)
+}
+
+func downloadVerifyAndExecute(source *Source, userToken uintptr) (progress chan DownloadProgress) {
 	progress = make(chan DownloadProgress, 128)
 	progress <- DownloadProgress{Activity: "Initializing"}
 
@@ -112,70 +378,34 @@ func DownloadVerifyAndExecute(userToken uintptr) (progress chan DownloadProgress
 	doIt := func() {
 		defer atomic.StoreUint32(&updateInProgress, 0)
 
-		progress <- DownloadProgress{Activity: "Checking for update"}
-		update, session, connection, err := checkForUpdate(true)
+		update, file, err := downloadAndVerify(source, progress)
 		if err != nil {
 			progress <- DownloadProgress{Error: err}
 			return
 		}
-		defer connection.Close()
-		defer session.Close()
-		if update == nil {
-			progress <- DownloadProgress{Error: errors.New("No update was found")}
-			return
-		}
+		defer file.Delete()
 
-		progress <- DownloadProgress{Activity: "Creating temporary file"}
-		file, err := msiTempFile()
+		progress <- DownloadProgress{Activity: "Keeping the installed version to roll back to"}
+		err = prepareRollback(update, file.ExclusivePath())
 		if err != nil {
-			progress <- DownloadProgress{Error: err}
-			return
-		}
-		progress <- DownloadProgress{Activity: fmt.Sprintf("Msi destination is %#q", file.Name())}
-		defer func() {
-			if file != nil {
-				file.Delete()
-			}
-		}()
-
-		dp := DownloadProgress{Activity: "Downloading update"}
-		progress <- dp
-		response, err := connection.Get(fmt.Sprintf(msiPath, update.name), false)
-		if err != nil {
-			progress <- DownloadProgress{Error: err}
-			return
-		}
-		defer response.Close()
-		length, err := response.Length()
-		if err == nil && length >= 0 {
-			dp.BytesTotal = length
-			progress <- dp
-		}
-		hasher, err := blake2b.New256(nil)
-		if err != nil {
-			progress <- DownloadProgress{Error: err}
-			return
-		}
-		pm := &progressHashWatcher{&dp, progress, hasher}
-		_, err = io.Copy(file, io.TeeReader(io.LimitReader(response, 1024*1024*100 /* 100 MiB */), pm))
-		if err != nil {
-			progress <- DownloadProgress{Error: err}
-			return
-		}
-		if !hmac.Equal(hasher.Sum(nil), update.hash[:]) {
-			progress <- DownloadProgress{Error: errors.New("The downloaded update has the wrong hash")}
-			return
-		}
-
-		progress <- DownloadProgress{Activity: "Verifying authenticode signature"}
-		if !verifyAuthenticode(file.ExclusivePath()) {
-			progress <- DownloadProgress{Error: errors.New("The downloaded update does not have an authentic authenticode signature")}
-			return
+			progress <- DownloadProgress{Activity: fmt.Sprintf("%v, so a failed update cannot be rolled back", err)}
 		}
 
 		progress <- DownloadProgress{Activity: "Installing update"}
 		err = runMsi(file, userToken)
 		if err != nil {
+			if msiChangedNothing(err) {
+				discardPendingInstall()
+			} else {
+				// Had the installer got far, this would have been stopped,
+				// leaving the manager to roll back once started again.
+				progress <- DownloadProgress{Activity: fmt.Sprintf("Installing update failed (%v), so rolling back", err)}
+				if failure, rollbackErr := recoverFailedInstallAs(userToken); rollbackErr != nil {
+					err = fmt.Errorf("%w, and rolling back failed: %v", err, rollbackErr)
+				} else if failure != nil && failure.RolledBack {
+					err = fmt.Errorf("%w, so the previous version was reinstalled", err)
+				}
+			}
 			progress <- DownloadProgress{Error: err}
 			return
 		}
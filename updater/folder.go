@@ -0,0 +1,51 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// readFolderFile reads the file at path in an update folder, of at most 512
+// KiB, or returns nil if it is optional and does not exist.
+func readFolderFile(path string, optional bool) ([]byte, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && optional {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, 1024*512 /* 512 KiB */))
+}
+
+// copyFrom copies the MSI at path in an update folder, from its start, since
+// there is nothing to gain by resuming a local copy.
+func (dl *download) copyFrom(path string, progress chan DownloadProgress) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = dl.restart(0)
+	if err != nil {
+		return err
+	}
+	dp := DownloadProgress{Activity: "Copying update"}
+	if info, err := file.Stat(); err == nil {
+		dp.BytesTotal = uint64(info.Size())
+	}
+	progress <- dp
+	if dp.BytesTotal > maxMsiSize {
+		return errors.New("The update is too large")
+	}
+	pm := newProgressHashWatcher(&dp, progress, dl)
+	_, err = io.Copy(pm, io.LimitReader(file, maxMsiSize))
+	return err
+}
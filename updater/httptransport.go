@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
+)
+
+// httpTransport is a transport using net/http rather than WinHTTP, which the
+// tests use, and which does not depend on Windows.
+type httpTransport struct {
+	client *http.Client
+	base   url.URL
+}
+
+func dialHTTP(source *Source) (transport, error) {
+	scheme := "http"
+	if source.UseHttps {
+		scheme = "https"
+	}
+	return &httpTransport{
+		client: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		base:   url.URL{Scheme: scheme, Host: net.JoinHostPort(source.Host, strconv.Itoa(int(source.Port)))},
+	}, nil
+}
+
+func (t *httpTransport) get(path string, header http.Header) (response, error) {
+	u, err := url.Parse(path)
+	if err != nil || len(u.Scheme) > 0 || len(u.Host) > 0 {
+		return nil, fmt.Errorf("Invalid path %#q", path)
+	}
+	request, err := http.NewRequest(http.MethodGet, t.base.ResolveReference(u).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header = header
+	request.Header.Set("User-Agent", version.UserAgent())
+	r, err := t.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	return &httpResponse{r}, nil
+}
+
+func (t *httpTransport) Get(path string, refresh bool) (response, error) {
+	header := make(http.Header)
+	if refresh {
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Pragma", "no-cache")
+	}
+	return t.get(path, header)
+}
+
+func (t *httpTransport) GetFrom(path string, offset uint64) (response, error) {
+	header := make(http.Header)
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return t.get(path, header)
+}
+
+func (t *httpTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+type httpResponse struct {
+	*http.Response
+}
+
+func (r *httpResponse) Read(p []byte) (int, error) {
+	return r.Body.Read(p)
+}
+
+func (r *httpResponse) Close() error {
+	return r.Body.Close()
+}
+
+func (r *httpResponse) StatusCode() (uint32, error) {
+	return uint32(r.Response.StatusCode), nil
+}
+
+func (r *httpResponse) ContentRange() (string, error) {
+	contentRange := r.Header.Get("Content-Range")
+	if len(contentRange) == 0 {
+		return "", errors.New("Response has no Content-Range")
+	}
+	return contentRange, nil
+}
+
+func (r *httpResponse) Length() (uint64, error) {
+	if r.ContentLength < 0 {
+		return 0, errors.New("Response has no length")
+	}
+	return uint64(r.ContentLength), nil
+}
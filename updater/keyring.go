@@ -0,0 +1,173 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ * Release keys are rotated and revoked by statements, each signed by a key
+ * that is trusted at that point, published one after another in keys.sig:
+ *   $ echo "trust $(tail -n 1 new.pub)" > statement
+ *   $ echo "revoke $KEYID" > statement
+ *   $ signify -S -e -s release.sec -m statement
+ *   $ cat statement.sig >> keys.sig
+ *   $ upload ./keys.sig
+ * where the key ID is the hex of the 8 bytes following "Ed" in a public key.
+ */
+
+// keyID is the key number of a signify key, which signatures carry to
+// identify the key that made them.
+type keyID [8]byte
+
+func (id keyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func parseKeyID(s string) (id keyID, err error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, errors.New("Invalid key ID")
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func parsePublicKey(s string) (keyID, ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PublicKeySize+10 || b[0] != 'E' || b[1] != 'd' {
+		return keyID{}, nil, errors.New("Invalid public key")
+	}
+	return keyID(b[2:10]), b[10:], nil
+}
+
+// keyring holds the keys trusted to sign releases, by their key ID, and the
+// IDs of the keys that were revoked, which are never trusted again.
+type keyring struct {
+	keys    map[keyID]ed25519.PublicKey
+	revoked map[keyID]bool
+	learned []string // Keys trusted by statements, in base64.
+	changed bool     // Whether statements trusted or revoked keys.
+}
+
+// newKeyring returns a keyring trusting publicKeys, apart from those with one
+// of the revoked IDs.
+func newKeyring(publicKeys, revoked []string) (*keyring, error) {
+	kr := &keyring{keys: make(map[keyID]ed25519.PublicKey), revoked: make(map[keyID]bool)}
+	for _, s := range revoked {
+		id, err := parseKeyID(s)
+		if err != nil {
+			return nil, err
+		}
+		kr.revoked[id] = true
+	}
+	for _, s := range publicKeys {
+		id, publicKey, err := parsePublicKey(s)
+		if err != nil {
+			return nil, err
+		}
+		if !kr.revoked[id] {
+			kr.keys[id] = publicKey
+		}
+	}
+	return kr, nil
+}
+
+func (kr *keyring) find(id keyID) (ed25519.PublicKey, error) {
+	if kr.revoked[id] {
+		return nil, fmt.Errorf("Signature is by revoked key %v", id)
+	}
+	publicKey, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("Signature is by untrusted key %v", id)
+	}
+	return publicKey, nil
+}
+
+// revokedIDs returns the revoked key IDs in hex, for persisting.
+func (kr *keyring) revokedIDs() []string {
+	ids := make([]string, 0, len(kr.revoked))
+	for id := range kr.revoked {
+		ids = append(ids, id.String())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// applyStatement trusts or revokes the key given by statement, which is a
+// line of the form "trust PUBLICKEY" or "revoke KEYID".
+func (kr *keyring) applyStatement(statement string) error {
+	verb, arg, _ := strings.Cut(statement, " ")
+	switch verb {
+	case "trust":
+		id, publicKey, err := parsePublicKey(arg)
+		if err != nil {
+			return err
+		}
+		if kr.revoked[id] {
+			return nil
+		}
+		if existing, ok := kr.keys[id]; ok {
+			if !existing.Equal(publicKey) {
+				return fmt.Errorf("Statement trusts a different key with ID %v", id)
+			}
+			return nil
+		}
+		kr.keys[id] = publicKey
+		kr.learned = append(kr.learned, arg)
+	case "revoke":
+		id, err := parseKeyID(arg)
+		if err != nil {
+			return err
+		}
+		if kr.revoked[id] {
+			return nil
+		}
+		delete(kr.keys, id)
+		kr.revoked[id] = true
+	default:
+		return errors.New("Unknown key statement")
+	}
+	kr.changed = true
+	return nil
+}
+
+// applyStatements verifies and applies the statements of keys.sig in order.
+// Statements signed by keys that were revoked since are skipped, as they were
+// applied before the revocation, and it may well have been for a replay of
+// them that the key was revoked.
+func (kr *keyring) applyStatements(input []byte) error {
+	lines := bytes.Split(input, []byte{'\n'})
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines)%3 != 0 {
+		return errors.New("Key statements have the wrong number of lines")
+	}
+	for i := 0; i < len(lines); i += 3 {
+		message := append(bytes.Clone(lines[i+2]), '\n')
+		err := verifySignature(lines[i], lines[i+1], message, kr)
+		if err != nil {
+			if signatureBytes, _ := base64.StdEncoding.DecodeString(string(lines[i+1])); len(signatureBytes) >= 10 && kr.revoked[keyID(signatureBytes[2:10])] {
+				continue
+			}
+			return fmt.Errorf("Key statement %d: %w", i/3+1, err)
+		}
+		err = kr.applyStatement(string(lines[i+2]))
+		if err != nil {
+			return fmt.Errorf("Key statement %d: %w", i/3+1, err)
+		}
+	}
+	return nil
+}
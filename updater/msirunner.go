@@ -10,13 +10,14 @@ import (
 	"encoding/hex"
 	"errors"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
 )
 
 type tempFile struct {
@@ -48,6 +49,11 @@ func (t *tempFile) Delete() error {
 }
 
 func runMsi(msi *tempFile, userToken uintptr) error {
+	return runMsiexec(msi.ExclusivePath(), userToken)
+}
+
+// runMsiexec installs the MSI at msiPath, passing properties to msiexec.
+func runMsiexec(msiPath string, userToken uintptr, properties ...string) error {
 	system32, err := windows.GetSystemDirectory()
 	if err != nil {
 		return err
@@ -57,7 +63,6 @@ func runMsi(msi *tempFile, userToken uintptr) error {
 		return err
 	}
 	defer devNull.Close()
-	msiPath := msi.ExclusivePath()
 	attr := &os.ProcAttr{
 		Sys: &syscall.SysProcAttr{
 			Token: syscall.Token(userToken),
@@ -66,7 +71,7 @@ func runMsi(msi *tempFile, userToken uintptr) error {
 		Dir:   filepath.Dir(msiPath),
 	}
 	msiexec := filepath.Join(system32, "msiexec.exe")
-	proc, err := os.StartProcess(msiexec, []string{msiexec, "/qb!-", "/i", filepath.Base(msiPath)}, attr)
+	proc, err := os.StartProcess(msiexec, append([]string{msiexec, "/qb!-", "/i", filepath.Base(msiPath)}, properties...), attr)
 	if err != nil {
 		return err
 	}
@@ -74,10 +79,79 @@ func runMsi(msi *tempFile, userToken uintptr) error {
 	if err != nil {
 		return err
 	}
-	if !state.Success() {
-		return &exec.ExitError{ProcessState: state}
+	return msiexecResult(uint32(state.ExitCode()))
+}
+
+// upgradeCodes are those of the MSIs of each architecture, as the installer
+// sets them, by which the product installed is found.
+var upgradeCodes = map[string]string{
+	"amd64": "{876B57E4-4490-4442-A983-721EE141B00D}",
+	"x86":   "{945200DA-4A7E-4B02-A06F-00FCD09E48DA}",
+	"arm":   "{220A4807-8E24-4594-9880-92034E062A57}",
+	"arm64": "{940BB00D-6A17-409C-93C6-0347E2DFED80}",
+}
+
+func productInfo(productCode, property string) (string, error) {
+	productCode16, err := windows.UTF16PtrFromString(productCode)
+	if err != nil {
+		return "", err
+	}
+	property16, err := windows.UTF16PtrFromString(property)
+	if err != nil {
+		return "", err
+	}
+	value := make([]uint16, windows.MAX_PATH)
+	for {
+		valueLen := uint32(len(value))
+		err = msiGetProductInfo(productCode16, property16, &value[0], &valueLen)
+		if err == windows.ERROR_MORE_DATA {
+			value = make([]uint16, valueLen+1)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return windows.UTF16ToString(value[:valueLen]), nil
 	}
-	return nil
+}
+
+// installedProduct returns the product code of the version installed.
+func installedProduct() (string, error) {
+	upgradeCode, ok := upgradeCodes[version.Arch()]
+	if !ok {
+		return "", errors.New("Unknown architecture")
+	}
+	upgradeCode16, err := windows.UTF16PtrFromString(upgradeCode)
+	if err != nil {
+		return "", err
+	}
+	var productCode16 [39]uint16
+	err = msiEnumRelatedProducts(upgradeCode16, 0, 0, &productCode16[0])
+	if err == windows.ERROR_NO_MORE_ITEMS {
+		return "", errors.New("AmneziaWG is not installed with an MSI")
+	} else if err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(productCode16[:]), nil
+}
+
+// installedProductVersion returns the version of the product installed.
+func installedProductVersion() (string, error) {
+	productCode, err := installedProduct()
+	if err != nil {
+		return "", err
+	}
+	return productInfo(productCode, "VersionString")
+}
+
+// reinstallMsi installs the MSI at path of the product with productCode,
+// forcing all of its files to be reinstalled, if that product is still
+// there.
+func reinstallMsi(path, productCode string, userToken uintptr) error {
+	if _, err := productInfo(productCode, "VersionString"); err == nil {
+		return runMsiexec(path, userToken, "REINSTALL=ALL", "REINSTALLMODE=amus")
+	}
+	return runMsiexec(path, userToken)
 }
 
 func msiTempFile() (*tempFile, error) {
@@ -114,3 +188,30 @@ func msiTempFile() (*tempFile, error) {
 		originalHandle: fileHandle,
 	}, nil
 }
+
+// openMsiTempFile reopens a file made by msiTempFile, provided it is still
+// owned by SYSTEM, so that its download may be resumed.
+func openMsiTempFile(name string) (*tempFile, error) {
+	name16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	fileHandle, err := windows.CreateFile(name16, windows.GENERIC_WRITE|windows.DELETE|windows.READ_CONTROL, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_TEMPORARY, 0)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := windows.GetSecurityInfo(fileHandle, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		windows.CloseHandle(fileHandle)
+		return nil, err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil || !owner.IsWellKnown(windows.WinLocalSystemSid) {
+		windows.CloseHandle(fileHandle)
+		return nil, errors.New("Partial download is not owned by SYSTEM")
+	}
+	return &tempFile{
+		File:           os.NewFile(uintptr(fileHandle), name),
+		originalHandle: fileHandle,
+	}, nil
+}
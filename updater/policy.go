@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+)
+
+// Policy is how far the manager goes on its own once it finds an update.
+type Policy int
+
+const (
+	PolicyDisabled Policy = iota // Do not check for updates.
+	PolicyNotify                 // Notify, and leave installing to an admin.
+	PolicyDownload               // Also download the update in the background.
+	PolicyInstall                // Also install it in the maintenance window.
+)
+
+var policyNames = [...]string{
+	PolicyDisabled: "disabled",
+	PolicyNotify:   "notify",
+	PolicyDownload: "download",
+	PolicyInstall:  "install",
+}
+
+func (policy Policy) String() string {
+	if policy < 0 || int(policy) >= len(policyNames) {
+		return "unknown"
+	}
+	return policyNames[policy]
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	for policy, name := range policyNames {
+		if strings.EqualFold(s, name) {
+			return Policy(policy), nil
+		}
+	}
+	return PolicyNotify, fmt.Errorf("Unknown update policy %#q", s)
+}
+
+// MaintenanceWindow is a daily span of local time, from Start up to End,
+// which wraps over midnight when End is not after Start.
+type MaintenanceWindow struct {
+	Start, End time.Duration // Time of day, from midnight.
+}
+
+// DefaultMaintenanceWindow is used for the install policy when no window is
+// set, at night, when machines are least likely to be in use.
+var DefaultMaintenanceWindow = MaintenanceWindow{Start: 3 * time.Hour, End: 5 * time.Hour}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var hours, minutes int
+	if len(s) != 5 || s[2] != ':' {
+		return 0, fmt.Errorf("Invalid time of day %#q", s)
+	}
+	_, err := fmt.Sscanf(s, "%02d:%02d", &hours, &minutes)
+	if err != nil || hours > 23 || minutes > 59 || hours < 0 || minutes < 0 {
+		return 0, fmt.Errorf("Invalid time of day %#q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// ParseMaintenanceWindow parses a window of the form HH:MM-HH:MM, in 24-hour
+// local time.
+func ParseMaintenanceWindow(s string) (MaintenanceWindow, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return MaintenanceWindow{}, fmt.Errorf("Invalid maintenance window %#q", s)
+	}
+	var window MaintenanceWindow
+	var err error
+	if window.Start, err = parseTimeOfDay(strings.TrimSpace(start)); err != nil {
+		return MaintenanceWindow{}, err
+	}
+	if window.End, err = parseTimeOfDay(strings.TrimSpace(end)); err != nil {
+		return MaintenanceWindow{}, err
+	}
+	if window.Start == window.End {
+		return MaintenanceWindow{}, fmt.Errorf("Empty maintenance window %#q", s)
+	}
+	return window, nil
+}
+
+// Contains returns whether t falls in the window, in t's location.
+func (window MaintenanceWindow) Contains(t time.Time) bool {
+	hour, minute, second := t.Clock()
+	now := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+	if window.Start < window.End {
+		return now >= window.Start && now < window.End
+	}
+	return now >= window.Start || now < window.End
+}
+
+func (window MaintenanceWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(window.Start.Hours()), int(window.Start.Minutes())%60, int(window.End.Hours()), int(window.End.Minutes())%60)
+}
+
+// CurrentPolicy returns the update policy set by the admin, defaulting to
+// notify, and the maintenance window for the install policy.
+func CurrentPolicy() (Policy, MaintenanceWindow, error) {
+	policy, window := PolicyNotify, DefaultMaintenanceWindow
+	var err error
+	if s := services.AdminKeyString(updatePolicyAdminKey); len(s) > 0 {
+		if policy, err = ParsePolicy(s); err != nil {
+			return PolicyNotify, window, fmt.Errorf("Invalid %s admin registry value: %w", updatePolicyAdminKey, err)
+		}
+	}
+	if s := services.AdminKeyString(maintenanceWindowAdminKey); len(s) > 0 {
+		if window, err = ParseMaintenanceWindow(s); err != nil {
+			return policy, DefaultMaintenanceWindow, fmt.Errorf("Invalid %s admin registry value: %w", maintenanceWindowAdminKey, err)
+		}
+	}
+	return policy, window, nil
+}
@@ -0,0 +1,64 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"time"
+)
+
+const (
+	// progressInterval is the least time between reports of bytes downloaded,
+	// which would otherwise be sent for every write, flooding the channel and
+	// any UI listening.
+	progressInterval = time.Millisecond * 250
+	// rateSmoothing is the weight given to the latest throughput, against that
+	// measured before, so that the estimates do not jump about.
+	rateSmoothing = 0.2
+)
+
+// throughputMeter estimates the throughput of a download from samples of the
+// bytes downloaded so far, taken at most every progressInterval.
+type throughputMeter struct {
+	last      time.Time
+	lastBytes uint64
+	rate      float64 // Bytes per second, exponentially smoothed.
+	sampled   bool
+}
+
+func newThroughputMeter(now time.Time, bytes uint64) throughputMeter {
+	return throughputMeter{last: now, lastBytes: bytes}
+}
+
+// sample notes that bytes have been downloaded by now, and returns whether
+// progressInterval has passed since the last sample, in which case it updates
+// the throughput.
+func (m *throughputMeter) sample(now time.Time, bytes uint64) bool {
+	elapsed := now.Sub(m.last)
+	if elapsed < progressInterval {
+		return false
+	}
+	current := float64(bytes-m.lastBytes) / elapsed.Seconds()
+	if m.sampled {
+		m.rate += rateSmoothing * (current - m.rate)
+	} else {
+		m.rate, m.sampled = current, true
+	}
+	m.last, m.lastBytes = now, bytes
+	return true
+}
+
+func (m *throughputMeter) bytesPerSecond() uint64 {
+	return uint64(m.rate)
+}
+
+// remaining estimates the time left to download total bytes, of which bytes
+// are done, or returns zero if it is unknown.
+func (m *throughputMeter) remaining(bytes, total uint64) time.Duration {
+	if m.rate < 1 || total <= bytes {
+		return 0
+	}
+	return time.Duration(float64(total-bytes) / m.rate * float64(time.Second)).Round(time.Second)
+}
@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+	"github.com/amnezia-vpn/amneziawg-windows-client/updater/winhttp"
+)
+
+// parseProxy parses a proxy setting, which is empty for the system settings,
+// "direct" for none, "auto" for auto-detection, an http or https URL of a
+// proxy auto-config script, or otherwise a proxy as host:port.
+func parseProxy(s string) (winhttp.Proxy, error) {
+	switch {
+	case len(s) == 0:
+		return winhttp.Proxy{}, nil
+	case strings.EqualFold(s, "direct"):
+		return winhttp.Proxy{Direct: true}, nil
+	case strings.EqualFold(s, "auto"):
+		return winhttp.Proxy{AutoDetect: true}, nil
+	case strings.Contains(s, "://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return winhttp.Proxy{}, err
+		}
+		if scheme := strings.ToLower(u.Scheme); (scheme != "http" && scheme != "https") || len(u.Hostname()) == 0 {
+			return winhttp.Proxy{}, errors.New("Proxy auto-config script must be at an http or https URL")
+		}
+		return winhttp.Proxy{PacURL: s}, nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || len(host) == 0 {
+		return winhttp.Proxy{}, errors.New("Proxy must be of the form host:port")
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return winhttp.Proxy{}, errors.New("Invalid proxy port")
+	}
+	return winhttp.Proxy{Server: s}, nil
+}
+
+// CurrentProxy returns how the updater reaches servers, as set by the admin,
+// which defaults to the system proxy settings.
+func CurrentProxy() (winhttp.Proxy, error) {
+	proxy, err := parseProxy(services.AdminKeyString(updateProxyAdminKey))
+	if err != nil {
+		return proxy, fmt.Errorf("Invalid %s admin registry value: %w", updateProxyAdminKey, err)
+	}
+	proxy.Username = services.AdminKeyString(updateProxyUsernameAdminKey)
+	if len(services.AdminKeyString(updateProxyPasswordAdminKey)) > 0 {
+		return proxy, fmt.Errorf("Invalid %s admin registry value: the password must be set with /setupdateproxypassword rather than in plaintext", updateProxyPasswordAdminKey)
+	}
+	proxy.Password, err = currentProxyPassword()
+	if err != nil {
+		return proxy, fmt.Errorf("Invalid %s admin registry value: %w", updateProxyPasswordAdminKey, err)
+	}
+	return proxy, nil
+}
@@ -0,0 +1,76 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/elevate"
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+)
+
+// The admin key is readable by every local user, so the proxy password is kept
+// there encrypted by DPAPI for SYSTEM, which only SYSTEM can then decrypt.
+var proxyPasswordEntropy = []byte("AmneziaWG update proxy password")
+
+func blobOf(b []byte) *windows.DataBlob {
+	if len(b) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(b)), Data: &b[0]}
+}
+
+func takeBlob(blob *windows.DataBlob) []byte {
+	if blob.Data == nil {
+		return nil
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte{}, unsafe.Slice(blob.Data, blob.Size)...)
+}
+
+// asSystem runs f as SYSTEM, without nesting an impersonation that is
+// already in effect, since reverting it would end the outer one too.
+func asSystem(f func() error) error {
+	user, err := windows.GetCurrentThreadEffectiveToken().GetTokenUser()
+	if err == nil && user.User.Sid.IsWellKnown(windows.WinLocalSystemSid) {
+		return f()
+	}
+	return elevate.DoAsSystem(f)
+}
+
+// SetProxyPassword encrypts password for SYSTEM and stores it as the
+// UpdateProxyPassword admin registry value, or removes the value if password
+// is empty. It requires being elevated.
+func SetProxyPassword(password string) error {
+	if len(password) == 0 {
+		return services.SetAdminKeyBinary(updateProxyPasswordAdminKey, nil)
+	}
+	var out windows.DataBlob
+	err := asSystem(func() error {
+		return windows.CryptProtectData(blobOf([]byte(password)), nil, blobOf(proxyPasswordEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	})
+	if err != nil {
+		return err
+	}
+	return services.SetAdminKeyBinary(updateProxyPasswordAdminKey, takeBlob(&out))
+}
+
+func currentProxyPassword() (string, error) {
+	encrypted, ok := services.AdminKeyBinary(updateProxyPasswordAdminKey)
+	if !ok {
+		return "", nil
+	}
+	var out windows.DataBlob
+	err := asSystem(func() error {
+		return windows.CryptUnprotectData(blobOf(encrypted), nil, blobOf(proxyPasswordEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(takeBlob(&out)), nil
+}
@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
+)
+
+// Severity says how urgently a release should be installed.
+type Severity uint32
+
+const (
+	SeverityRoutine Severity = iota
+	SeverityImportant
+	SeveritySecurity
+)
+
+var severityNames = [...]string{
+	SeverityRoutine:   "routine",
+	SeverityImportant: "important",
+	SeveritySecurity:  "security",
+}
+
+func (severity Severity) String() string {
+	if int(severity) < len(severityNames) {
+		return severityNames[severity]
+	}
+	return fmt.Sprintf("Severity(%d)", severity)
+}
+
+// ParseSeverity parses the name of a severity, ignoring case.
+func ParseSeverity(s string) (Severity, error) {
+	for severity, name := range severityNames {
+		if strings.EqualFold(s, name) {
+			return Severity(severity), nil
+		}
+	}
+	return SeverityRoutine, fmt.Errorf("Unknown severity %#q", s)
+}
+
+// ReleaseNotes describe a release, and are trusted since the signed list
+// gives their hash. The zero value means that there are none.
+type ReleaseNotes struct {
+	Version   string
+	Date      time.Time
+	Severity  Severity
+	Changelog string
+}
+
+// releaseNotesName is the name of the notes of release v, which are listed
+// next to its MSIs, and shared by all architectures.
+func releaseNotesName(v version.Version) string {
+	return releaseNotesPrefix + v.String() + releaseNotesSuffix
+}
+
+// parseReleaseNotes parses the notes of release v, which are JSON like:
+//
+//	{"version": "1.0.5", "date": "2026-10-01", "severity": "security", "changelog": "..."}
+func parseReleaseNotes(input []byte, v version.Version) (*ReleaseNotes, error) {
+	var raw struct {
+		Version   string `json:"version"`
+		Date      string `json:"date"`
+		Severity  string `json:"severity"`
+		Changelog string `json:"changelog"`
+	}
+	err := json.Unmarshal(input, &raw)
+	if err != nil {
+		return nil, err
+	}
+	notesVersion, err := version.Parse(raw.Version)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid version %#q: %w", raw.Version, err)
+	}
+	if notesVersion.Compare(v) != 0 || notesVersion.Build != v.Build {
+		return nil, fmt.Errorf("Release notes are for version %v rather than %v", notesVersion, v)
+	}
+	date, err := time.Parse(time.DateOnly, raw.Date)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid release date %#q", raw.Date)
+	}
+	severity, err := ParseSeverity(raw.Severity)
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseNotes{
+		Version:   notesVersion.String(),
+		Date:      date,
+		Severity:  severity,
+		Changelog: strings.TrimSpace(strings.ReplaceAll(raw.Changelog, "\r\n", "\n")),
+	}, nil
+}
+
+// readReleaseNotes reads the notes of update from source, if the signed list
+// files has any, and checks them against the hash it gives.
+func readReleaseNotes(source *Source, t transport, channel Channel, files fileList, update *UpdateFound) (*ReleaseNotes, error) {
+	name := releaseNotesName(update.version)
+	hash, ok := files[name]
+	if !ok {
+		return nil, nil
+	}
+	input, err := readSourceFile(source, t, source.msiPath(channel, name), false)
+	if err != nil {
+		return nil, err
+	}
+	actual := blake2b.Sum256(input)
+	if !hmac.Equal(actual[:], hash[:]) {
+		return nil, errors.New("The release notes have the wrong hash")
+	}
+	return parseReleaseNotes(input, update.version)
+}
@@ -0,0 +1,277 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"crypto/hmac"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	maxMsiSize       = 1024 * 1024 * 100 /* 100 MiB */
+	downloadAttempts = 8
+)
+
+// The delays between attempts to download, which the tests shorten.
+var (
+	minRetryDelay = time.Second * 2
+	maxRetryDelay = time.Minute
+)
+
+// partialDownload is an interrupted download of an MSI, remembered so that the
+// next attempt to update resumes it rather than starting over.
+type partialDownload struct {
+	Name      string `json:"name"`       // Name of the MSI in the signed list.
+	Hash      string `json:"hash"`       // Its hash in the signed list, in hex.
+	Path      string `json:"path"`       // Temporary file holding the bytes downloaded so far.
+	Length    uint64 `json:"length"`     // Number of bytes downloaded so far.
+	HashState []byte `json:"hash_state"` // BLAKE2b state after hashing those bytes.
+}
+
+// download is an MSI being downloaded to a file, hashing the bytes as they are
+// written to it.
+type download struct {
+	file   *os.File
+	hasher hash.Hash
+	length uint64
+}
+
+func newDownload(file *os.File) (*download, error) {
+	hasher, err := blake2b.New256(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &download{file: file, hasher: hasher}, nil
+}
+
+// resumeDownload restores the download of partial to file, which holds at
+// least the bytes downloaded so far.
+func resumeDownload(file *os.File, partial *partialDownload) (*download, error) {
+	dl, err := newDownload(file)
+	if err != nil {
+		return nil, err
+	}
+	err = dl.hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(partial.HashState)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(info.Size()) < partial.Length || partial.Length > maxMsiSize {
+		return nil, errors.New("Partial download is shorter than remembered")
+	}
+	err = dl.restart(partial.Length)
+	if err != nil {
+		return nil, err
+	}
+	dl.length = partial.Length
+	return dl, nil
+}
+
+// restart discards the bytes of the file beyond length, and the hash state
+// too if it is zero.
+func (dl *download) restart(length uint64) error {
+	if length == 0 {
+		dl.hasher.Reset()
+	}
+	dl.length = length
+	err := dl.file.Truncate(int64(length))
+	if err != nil {
+		return err
+	}
+	_, err = dl.file.Seek(int64(length), io.SeekStart)
+	return err
+}
+
+func (dl *download) Write(p []byte) (int, error) {
+	n, err := dl.file.Write(p)
+	dl.hasher.Write(p[:n])
+	dl.length += uint64(n)
+	return n, err
+}
+
+// matches returns whether the bytes downloaded have the hash of update.
+func (dl *download) matches(update *UpdateFound) bool {
+	return hmac.Equal(dl.hasher.Sum(nil), update.hash[:])
+}
+
+func (dl *download) partial(update *UpdateFound) (*partialDownload, error) {
+	hashState, err := dl.hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &partialDownload{
+		Name:      update.name,
+		Hash:      hex.EncodeToString(update.hash[:]),
+		Path:      dl.file.Name(),
+		Length:    dl.length,
+		HashState: hashState,
+	}, nil
+}
+
+// fetchFrom makes one attempt at downloading the rest of the MSI at path,
+// starting over if the server does not support resuming.
+func (dl *download) fetchFrom(t transport, path string, dp *DownloadProgress, progress chan DownloadProgress) error {
+	response, err := t.GetFrom(path, dl.length)
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+	status, err := response.StatusCode()
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == 206:
+		contentRange, err := response.ContentRange()
+		if err != nil {
+			return err
+		}
+		start, err := rangeStart(contentRange)
+		if err != nil || start != dl.length {
+			// Appending the bytes would corrupt the file, so start over, with a
+			// request that the server cannot get wrong this way.
+			progress <- DownloadProgress{Activity: fmt.Sprintf("Server resumed the download at the wrong offset (%#q), so starting over", contentRange)}
+			restartErr := dl.restart(0)
+			if restartErr != nil {
+				return restartErr
+			}
+			return errors.New("Server resumed the download at the wrong offset")
+		}
+	case status == 200 || status == 416:
+		if dl.length > 0 {
+			progress <- DownloadProgress{Activity: "Server cannot resume the download, so starting over"}
+			err = dl.restart(0)
+			if err != nil {
+				return err
+			}
+		}
+		if status == 416 {
+			return errors.New("Server cannot resume the download")
+		}
+	default:
+		return &permanentError{fmt.Errorf("Update server returned HTTP status %d for %#q", status, path)}
+	}
+	dp.BytesDownloaded = dl.length
+	dp.BytesTotal = 0
+	if length, err := response.Length(); err == nil {
+		dp.BytesTotal = dl.length + length
+	}
+	progress <- *dp
+	if dl.length >= maxMsiSize {
+		return &permanentError{errors.New("The update is too large")}
+	}
+	pm := newProgressHashWatcher(dp, progress, dl)
+	n, err := io.Copy(pm, io.LimitReader(response, int64(maxMsiSize-dl.length)))
+	if err != nil {
+		return err
+	}
+	if n == 0 || (dp.BytesTotal > 0 && dl.length < dp.BytesTotal) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// permanentError is an error that retrying would not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// fetch downloads the rest of the MSI at path, retrying with exponential
+// backoff when interrupted.
+func (dl *download) fetch(t transport, path string, progress chan DownloadProgress) error {
+	dp := DownloadProgress{Activity: "Downloading update", BytesDownloaded: dl.length}
+	if dl.length > 0 {
+		dp.Activity = fmt.Sprintf("Resuming download after %d bytes", dl.length)
+	}
+	progress <- dp
+	delay := minRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := dl.fetchFrom(t, path, &dp, progress)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || attempt == downloadAttempts {
+			return err
+		}
+		progress <- DownloadProgress{Activity: fmt.Sprintf("Download interrupted after %d bytes (%v), so retrying in %v", dl.length, err, delay)}
+		time.Sleep(delay)
+		delay = min(delay*2, maxRetryDelay)
+		dp.Activity = "Resuming download"
+	}
+}
+
+// openDownload resumes the partial download of update, if any, or otherwise
+// starts a new one in a fresh temporary file. Partial downloads of other
+// MSIs are deleted.
+func openDownload(update *UpdateFound, progress chan DownloadProgress) (*tempFile, *download, error) {
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return nil, nil, err
+	}
+	if partial := state.PartialDownload; partial != nil {
+		state.PartialDownload = nil
+		err = state.persist()
+		if err != nil {
+			return nil, nil, err
+		}
+		file, err := openMsiTempFile(partial.Path)
+		if err == nil {
+			if partial.Name == update.name && partial.Hash == hex.EncodeToString(update.hash[:]) {
+				dl, err := resumeDownload(file.File, partial)
+				if err == nil {
+					progress <- DownloadProgress{Activity: fmt.Sprintf("Msi destination is %#q", file.Name())}
+					return file, dl, nil
+				}
+				progress <- DownloadProgress{Activity: fmt.Sprintf("Unable to resume download: %v", err)}
+			}
+			file.Delete()
+		}
+	}
+	progress <- DownloadProgress{Activity: "Creating temporary file"}
+	file, err := msiTempFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	progress <- DownloadProgress{Activity: fmt.Sprintf("Msi destination is %#q", file.Name())}
+	dl, err := newDownload(file.File)
+	if err != nil {
+		file.Delete()
+		return nil, nil, err
+	}
+	return file, dl, nil
+}
+
+// savePartialDownload remembers dl, so that the next attempt to update resumes
+// it.
+func savePartialDownload(dl *download, update *UpdateFound) error {
+	partial, err := dl.partial(update)
+	if err != nil {
+		return err
+	}
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return err
+	}
+	state.PartialDownload = partial
+	return state.persist()
+}
@@ -0,0 +1,323 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/amnezia-vpn/amneziawg-windows/conf"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
+)
+
+// The MSIs kept next to the configurations, so that only SYSTEM may change
+// them, for rolling back failed updates.
+const (
+	installedMsiName = "updater-installed.msi" // Of the version installed, if the updater installed it.
+	previousMsiName  = "updater-previous.msi"  // Of the version being replaced by an update.
+	nextMsiName      = "updater-next.msi"      // Of the update being installed.
+)
+
+// pendingInstall is an update being installed, remembered so that the next
+// start can tell whether installing it failed, and if so reinstall the version
+// that it replaced.
+type pendingInstall struct {
+	From        string `json:"from"`                   // Version being replaced.
+	To          string `json:"to"`                     // Version being installed.
+	ProductCode string `json:"product_code,omitempty"` // Product code of the version being replaced.
+	Previous    bool   `json:"previous,omitempty"`     // Whether the MSI of From was kept to roll back to.
+	Next        bool   `json:"next,omitempty"`         // Whether the MSI of To was kept to roll back to later.
+}
+
+// reinstall installs the MSI of a version rolled back to, which the tests
+// replace.
+var reinstall = reinstallMsi
+
+// productVersion returns the version of the product installed, as Windows
+// Installer records it, which the tests replace.
+var productVersion = installedProductVersion
+
+// Exit codes with which msiexec reports having installed, though a restart
+// is needed to finish.
+const (
+	msiexecRebootInitiated = 1641 // ERROR_SUCCESS_REBOOT_INITIATED
+	msiexecRebootRequired  = 3010 // ERROR_SUCCESS_REBOOT_REQUIRED
+)
+
+// Exit codes with which msiexec reports having failed before changing
+// anything.
+const (
+	msiexecUserExit        = 1602 // ERROR_INSTALL_USEREXIT
+	msiexecPackageRejected = 1625 // ERROR_INSTALL_PACKAGE_REJECTED
+	msiexecAlreadyRunning  = 1618 // ERROR_INSTALL_ALREADY_RUNNING
+	msiexecProductVersion  = 1638 // ERROR_PRODUCT_VERSION
+)
+
+// msiexecError is msiexec exiting with an exit code that reports a failure.
+type msiexecError uint32
+
+func (e msiexecError) Error() string {
+	return fmt.Sprintf("msiexec failed with exit code %d", uint32(e))
+}
+
+// msiexecResult returns the error reported by msiexec exiting with code.
+func msiexecResult(code uint32) error {
+	switch code {
+	case 0, msiexecRebootInitiated, msiexecRebootRequired:
+		return nil
+	}
+	return msiexecError(code)
+}
+
+// msiChangedNothing returns whether err is the failure of msiexec to even
+// start installing, so that there is nothing to roll back.
+func msiChangedNothing(err error) bool {
+	var code msiexecError
+	if !errors.As(err, &code) {
+		return false
+	}
+	switch code {
+	case msiexecUserExit, msiexecPackageRejected, msiexecAlreadyRunning, msiexecProductVersion:
+		return true
+	}
+	return false
+}
+
+// InstallFailure is an update that failed to install.
+type InstallFailure struct {
+	From       string `json:"from"`        // Version that the update would have replaced.
+	To         string `json:"to"`          // Version of the update.
+	RolledBack bool   `json:"rolled_back"` // Whether From was reinstalled.
+}
+
+func copyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destination, io.LimitReader(source, maxMsiSize))
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
+
+// sameVersion returns whether a and b are the same version, as ordered by
+// semantic versioning, rather than the same string.
+func sameVersion(a, b string) bool {
+	va, err := version.Parse(a)
+	if err != nil {
+		return false
+	}
+	vb, err := version.Parse(b)
+	if err != nil {
+		return false
+	}
+	return va.Compare(vb) == 0
+}
+
+// isInstalledVersion returns whether installed, a version as Windows Installer
+// records it, is v, disregarding the pre-release and build metadata of v,
+// which the version of an MSI cannot hold.
+func isInstalledVersion(installed, v string) bool {
+	vi, err := version.Parse(installed)
+	if err != nil {
+		return false
+	}
+	vv, err := version.Parse(v)
+	if err != nil {
+		return false
+	}
+	vv.PreRelease, vv.Build = nil, ""
+	return vi.Compare(vv) == 0
+}
+
+// keepPreviousMsi keeps the MSI that the updater installed the version
+// installed from as previousMsiName in root, and returns the product code of
+// that version. That which Windows Installer caches is not used, as it usually
+// lacks the files embedded in the original, so a version that the updater did
+// not install cannot be kept.
+func keepPreviousMsi(state *updaterState, root string) (string, error) {
+	productCode, err := installedProduct()
+	if err != nil {
+		return "", err
+	}
+	if !sameVersion(state.InstalledMsi, version.Number) {
+		return productCode, fmt.Errorf("The updater did not install version %s", version.Number)
+	}
+	return productCode, os.Rename(filepath.Join(root, installedMsiName), filepath.Join(root, previousMsiName))
+}
+
+// prepareRollback remembers that update is about to be installed from msi,
+// keeping the MSI of the version installed, so that the next start can
+// reinstall it if installing update fails, and that of update, so that it can
+// be reinstalled if a later update fails. Failing to keep the MSI of the
+// version installed is returned as an error, after which the update may still
+// be installed, but not rolled back.
+func prepareRollback(update *UpdateFound, msi string) error {
+	root, err := conf.RootDirectory(true)
+	if err != nil {
+		return err
+	}
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return err
+	}
+	pending := &pendingInstall{From: version.Number, To: update.version.String()}
+	pending.ProductCode, err = keepPreviousMsi(state, root)
+	pending.Previous = err == nil
+	state.InstalledMsi = ""
+	pending.Next = copyFile(msi, filepath.Join(root, nextMsiName)) == nil
+	state.PendingInstall = pending
+	if persistErr := state.persist(); persistErr != nil {
+		return persistErr
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to keep version %s to roll back to: %w", pending.From, err)
+	}
+	return nil
+}
+
+// discardPendingInstall forgets the update about to be installed, once it is
+// known not to have changed anything.
+func discardPendingInstall() error {
+	root, err := conf.RootDirectory(false)
+	if err != nil {
+		return err
+	}
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return err
+	}
+	pending := state.PendingInstall
+	if pending == nil {
+		return nil
+	}
+	state.PendingInstall = nil
+	if pending.Previous {
+		if os.Rename(filepath.Join(root, previousMsiName), filepath.Join(root, installedMsiName)) == nil {
+			state.InstalledMsi = pending.From
+		}
+	}
+	os.Remove(filepath.Join(root, nextMsiName))
+	return state.persist()
+}
+
+// recoverFailedInstall checks whether the update that state says was being
+// installed failed to, which it tells by installed, the version of the product
+// installed, or empty if there is none, not being that of the update nor of
+// another version since, and if so reinstalls the version that the update
+// replaced from the MSI kept in root. It returns nil if no update was being
+// installed, or if it was installed.
+func recoverFailedInstall(state *updaterState, root, installed string, userToken uintptr) (*InstallFailure, error) {
+	pending := state.PendingInstall
+	if pending == nil {
+		os.Remove(filepath.Join(root, previousMsiName))
+		os.Remove(filepath.Join(root, nextMsiName))
+		return nil, nil
+	}
+	state.PendingInstall = nil
+	// An update whose version only differs from the one it replaces in its
+	// pre-release is taken to have installed, rather than to be rolled back.
+	if isInstalledVersion(installed, pending.To) || (len(installed) > 0 && !isInstalledVersion(installed, pending.From)) {
+		// Either the update was installed, or another version was since.
+		os.Remove(filepath.Join(root, previousMsiName))
+		if isInstalledVersion(installed, pending.To) {
+			state.InstallFailure = nil
+			if pending.Next && os.Rename(filepath.Join(root, nextMsiName), filepath.Join(root, installedMsiName)) == nil {
+				state.InstalledMsi = pending.To
+			}
+		}
+		os.Remove(filepath.Join(root, nextMsiName))
+		return nil, state.persist()
+	}
+	os.Remove(filepath.Join(root, nextMsiName))
+	failure := &InstallFailure{From: pending.From, To: pending.To}
+	state.InstallFailure = failure
+	if !pending.Previous {
+		err := state.persist()
+		if err != nil {
+			return failure, err
+		}
+		return failure, fmt.Errorf("Version %s was not kept, so it cannot be reinstalled", pending.From)
+	}
+	// Reinstalling restarts the manager, which is likely to be stopped before
+	// it is done, so the MSI is kept as the one installed, and the failure
+	// remembered as rolled back, beforehand.
+	msi := filepath.Join(root, installedMsiName)
+	err := os.Rename(filepath.Join(root, previousMsiName), msi)
+	if err != nil {
+		state.persist()
+		return failure, err
+	}
+	state.InstalledMsi = pending.From
+	failure.RolledBack = true
+	err = state.persist()
+	if err != nil {
+		return failure, err
+	}
+	err = reinstall(msi, pending.ProductCode, userToken)
+	if err != nil {
+		failure.RolledBack = false
+		state.persist()
+		return failure, fmt.Errorf("Unable to reinstall version %s: %w", pending.From, err)
+	}
+	return failure, nil
+}
+
+// RecoverFailedInstall checks whether the update installed last failed to
+// install, and if so reinstalls the version that it replaced, as SYSTEM,
+// returning the failure. It returns nil if no update was being installed, or
+// if it was installed.
+func RecoverFailedInstall() (*InstallFailure, error) {
+	return recoverFailedInstallAs(0)
+}
+
+func recoverFailedInstallAs(userToken uintptr) (*InstallFailure, error) {
+	root, err := conf.RootDirectory(false)
+	if err != nil {
+		return nil, err
+	}
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return nil, err
+	}
+	installed, err := productVersion()
+	if err != nil {
+		installed = ""
+	}
+	return recoverFailedInstall(state, root, installed, userToken)
+}
+
+// LastInstallFailure returns the last update that failed to install, until
+// one is installed.
+func LastInstallFailure() (*InstallFailure, error) {
+	updaterStateLock.Lock()
+	defer updaterStateLock.Unlock()
+	state, err := loadUpdaterState()
+	if err != nil {
+		return nil, err
+	}
+	return state.InstallFailure, nil
+}
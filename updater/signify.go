@@ -11,43 +11,109 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/blake2b"
 )
 
 /*
  * Generate with:
- *   $ b2sum -l 256 *.msi > list
+ *   $ echo "trusted comment: serial=$SERIAL issued=$(date -u +%FT%TZ) expires=$(date -u -d +30days +%FT%TZ)" > list
+ *   $ b2sum -l 256 *.msi >> list
  *   $ signify -S -e -s release.sec -m list
  *   $ upload ./list.sec
  */
 
 type fileList map[string][blake2b.Size256]byte
 
-func readFileList(input []byte) (fileList, error) {
-	publicKeyBytes, err := base64.StdEncoding.DecodeString(releasePublicKeyBase64)
-	if err != nil || len(publicKeyBytes) != ed25519.PublicKeySize+10 || publicKeyBytes[0] != 'E' || publicKeyBytes[1] != 'd' {
-		return nil, errors.New("Invalid public key")
+// listHeader is the trusted comment on the first line of the signed list,
+// which makes replaying an older list detectable.
+type listHeader struct {
+	serial  uint64 // Increases with every list published.
+	issued  time.Time
+	expires time.Time
+}
+
+func parseListHeader(line string) (*listHeader, error) {
+	fields, ok := strings.CutPrefix(line, "trusted comment: ")
+	if !ok {
+		return nil, errors.New("Signed list is missing trusted comment")
 	}
-	lines := bytes.SplitN(input, []byte{'\n'}, 3)
-	if len(lines) != 3 {
-		return nil, errors.New("Signature input has too few lines")
+	header := new(listHeader)
+	var err error
+	for _, field := range strings.Fields(fields) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "serial":
+			header.serial, err = strconv.ParseUint(value, 10, 64)
+		case "issued":
+			header.issued, err = time.Parse(time.RFC3339, value)
+		case "expires":
+			header.expires, err = time.Parse(time.RFC3339, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s in trusted comment", key)
+		}
+	}
+	if header.serial == 0 || header.issued.IsZero() || header.expires.IsZero() || !header.expires.After(header.issued) {
+		return nil, errors.New("Trusted comment lacks a valid serial, issue time, or expiry")
+	}
+	return header, nil
+}
+
+// checkFresh checks that the list is neither expired nor older than the list
+// with the highest serial seen before, and returns that serial.
+func (header *listHeader) checkFresh(now time.Time, highestSerial uint64) (uint64, error) {
+	if now.After(header.expires) {
+		return highestSerial, fmt.Errorf("Signed list expired on %v", header.expires.Format(time.DateTime))
+	}
+	if header.serial < highestSerial {
+		return highestSerial, fmt.Errorf("Signed list has serial %d, which is older than %d, seen before", header.serial, highestSerial)
+	}
+	return header.serial, nil
+}
+
+// verifySignature checks that the two lines of a signify signature, the
+// untrusted comment and the base64 signature, sign message with a key of kr.
+func verifySignature(comment, signature, message []byte, kr *keyring) error {
+	if !bytes.HasPrefix(comment, []byte("untrusted comment: ")) {
+		return errors.New("Signature input is missing untrusted comment")
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(string(signature))
+	if err != nil {
+		return errors.New("Signature input is not valid base64")
 	}
-	if !bytes.HasPrefix(lines[0], []byte("untrusted comment: ")) {
-		return nil, errors.New("Signature input is missing untrusted comment")
+	if len(signatureBytes) != ed25519.SignatureSize+10 || signatureBytes[0] != 'E' || signatureBytes[1] != 'd' {
+		return errors.New("Signature input bytes are incorrect length or type")
 	}
-	signatureBytes, err := base64.StdEncoding.DecodeString(string(lines[1]))
+	publicKey, err := kr.find(keyID(signatureBytes[2:10]))
 	if err != nil {
-		return nil, errors.New("Signature input is not valid base64")
+		return err
+	}
+	if !ed25519.Verify(publicKey, message, signatureBytes[10:]) {
+		return errors.New("Signature is invalid")
+	}
+	return nil
+}
+
+func readFileList(input []byte, kr *keyring) (fileList, *listHeader, error) {
+	lines := bytes.SplitN(input, []byte{'\n'}, 3)
+	if len(lines) != 3 {
+		return nil, nil, errors.New("Signature input has too few lines")
 	}
-	if len(signatureBytes) != ed25519.SignatureSize+10 || !bytes.Equal(signatureBytes[:10], publicKeyBytes[:10]) {
-		return nil, errors.New("Signature input bytes are incorrect length, type, or keyid")
+	err := verifySignature(lines[0], lines[1], lines[2], kr)
+	if err != nil {
+		return nil, nil, err
 	}
-	if !ed25519.Verify(publicKeyBytes[10:], lines[2], signatureBytes[10:]) {
-		return nil, errors.New("Signature is invalid")
+	headerLine, list, _ := strings.Cut(string(lines[2]), "\n")
+	header, err := parseListHeader(headerLine)
+	if err != nil {
+		return nil, nil, err
 	}
-	fileLines := strings.Split(string(lines[2]), "\n")
+	fileLines := strings.Split(list, "\n")
 	fileHashes := make(map[string][blake2b.Size256]byte, len(fileLines))
 	for index, line := range fileLines {
 		if len(line) == 0 && index == len(fileLines)-1 {
@@ -55,18 +121,18 @@ func readFileList(input []byte) (fileList, error) {
 		}
 		first, second, ok := strings.Cut(line, "  ")
 		if !ok {
-			return nil, errors.New("File hash line has too few components")
+			return nil, nil, errors.New("File hash line has too few components")
 		}
 		maybeHash, err := hex.DecodeString(first)
 		if err != nil || len(maybeHash) != blake2b.Size256 {
-			return nil, errors.New("File hash is invalid base64 or incorrect number of bytes")
+			return nil, nil, errors.New("File hash is invalid base64 or incorrect number of bytes")
 		}
 		var hash [blake2b.Size256]byte
 		copy(hash[:], maybeHash)
 		fileHashes[second] = hash
 	}
 	if len(fileHashes) == 0 {
-		return nil, errors.New("No file hashes found in signed input")
+		return nil, nil, errors.New("No file hashes found in signed input")
 	}
-	return fileHashes, nil
+	return fileHashes, header, nil
 }
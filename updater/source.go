@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/services"
+)
+
+// Source describes where releases are published, how their MSIs are named,
+// and which keys sign the list of them.
+type Source struct {
+	Host        string
+	Port        uint16
+	UseHttps    bool
+	Directories map[Channel]string // Path of the directory of the signed list and MSIs of each channel, ending in a slash.
+	KeysPath    string             // Path of the signed statements rotating and revoking keys, which is optional.
+	MsiPrefix   string             // Format of the start of MSI names, given the architecture.
+	MsiSuffix   string             // End of MSI names.
+	PublicKeys  []string           // Signify public keys, in base64, trusted to sign the lists and statements.
+	Folder      string             // Local directory or UNC share that the directories are in, rather than a server.
+}
+
+// DefaultSource is where AmneziaWG releases are published, with the lists of
+// the stable channel attached to the latest release, and those of the others
+// to the releases tagged after them. The admin may override where they are
+// fetched from and which keys sign them.
+var DefaultSource = Source{
+	Host:     updateServerHost,
+	Port:     updateServerPort,
+	UseHttps: updateServerUseHttps,
+	Directories: map[Channel]string{
+		ChannelStable:  releasesPath + "latest/download/",
+		ChannelBeta:    releasesPath + "download/beta/",
+		ChannelNightly: releasesPath + "download/nightly/",
+	},
+	KeysPath:   releasesPath + "latest/download/" + keysName,
+	MsiPrefix:  msiArchPrefix,
+	MsiSuffix:  msiSuffix,
+	PublicKeys: []string{releasePublicKeyBase64},
+}
+
+// URL returns the location of the signed list of channel, for logging.
+func (source *Source) URL(channel Channel) string {
+	if len(source.Folder) > 0 {
+		return source.listPath(channel)
+	}
+	scheme, port := "http", uint16(80)
+	if source.UseHttps {
+		scheme, port = "https", 443
+	}
+	host := source.Host
+	if source.Port != port || strings.Contains(host, ":") {
+		host = net.JoinHostPort(host, strconv.Itoa(int(source.Port)))
+	}
+	return (&url.URL{Scheme: scheme, Host: host, Path: source.listPath(channel)}).String()
+}
+
+func (source *Source) listPath(channel Channel) string {
+	return source.Directories[channel] + listName
+}
+
+func (source *Source) msiPath(channel Channel, name string) string {
+	if len(source.Folder) > 0 {
+		return source.Directories[channel] + name
+	}
+	return source.Directories[channel] + url.PathEscape(name)
+}
+
+// WithMirror returns a copy of source that fetches the signed lists and the
+// MSIs from the directory at mirror, which is an http or https URL, rather
+// than from where they are published, with those of the stable channel in the
+// directory itself, and those of the others in subdirectories named after
+// them. The lists must still be signed by one of the keys of source, or by a
+// key they trust.
+func (source Source) WithMirror(mirror string) (*Source, error) {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		source.UseHttps, source.Port = true, 443
+	case "http":
+		source.UseHttps, source.Port = false, 80
+	default:
+		return nil, errors.New("Mirror must be an http or https URL")
+	}
+	if len(u.Hostname()) == 0 || u.User != nil || len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return nil, errors.New("Mirror must be a URL of a directory on a host")
+	}
+	if port := u.Port(); len(port) > 0 {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return nil, errors.New("Invalid mirror port")
+		}
+		source.Port = uint16(p)
+	}
+	source.Host = u.Hostname()
+	directory := u.EscapedPath()
+	if !strings.HasSuffix(directory, "/") {
+		directory += "/"
+	}
+	source.Directories = make(map[Channel]string, len(Channels))
+	for _, channel := range Channels {
+		source.Directories[channel] = directory
+		if channel != ChannelStable {
+			source.Directories[channel] += string(channel) + "/"
+		}
+	}
+	source.KeysPath = directory + keysName
+	return &source, nil
+}
+
+// WithFolder returns a copy of source that reads the signed lists and the MSIs
+// from folder, which is an absolute path of a local directory or UNC share,
+// laid out like the directory of a mirror. This is meant for sites that cannot
+// reach any server, so the lists are not required to be unexpired, though they
+// must still be signed, and no older than those seen before.
+func (source Source) WithFolder(folder string) (*Source, error) {
+	if !filepath.IsAbs(folder) {
+		return nil, errors.New("Update folder must be an absolute path")
+	}
+	directory := filepath.Clean(folder)
+	if !strings.HasSuffix(directory, string(filepath.Separator)) {
+		directory += string(filepath.Separator)
+	}
+	source.Host, source.Port, source.UseHttps = "", 0, false
+	source.Folder = directory
+	source.Directories = make(map[Channel]string, len(Channels))
+	for _, channel := range Channels {
+		source.Directories[channel] = directory
+		if channel != ChannelStable {
+			source.Directories[channel] += string(channel) + string(filepath.Separator)
+		}
+	}
+	source.KeysPath = directory + keysName
+	return &source, nil
+}
+
+// CurrentSource returns the default source, or a folder or mirror of it set
+// by the admin, of which the folder takes precedence, trusting the keys set by
+// the admin instead of the default ones, if any.
+func CurrentSource() (*Source, error) {
+	var source *Source
+	var err error
+	if folder := services.AdminKeyString(updateFolderAdminKey); len(folder) > 0 {
+		source, err = DefaultSource.WithFolder(folder)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s admin registry value: %w", updateFolderAdminKey, err)
+		}
+	} else if mirror := services.AdminKeyString(updateServerAdminKey); len(mirror) > 0 {
+		source, err = DefaultSource.WithMirror(mirror)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s admin registry value: %w", updateServerAdminKey, err)
+		}
+	} else {
+		defaultSource := DefaultSource
+		source = &defaultSource
+	}
+	source.PublicKeys, err = CurrentPublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// CurrentPublicKeys returns the signify public keys trusted to sign the lists
+// of releases, which are those set by the admin, or otherwise the AmneziaWG
+// release key.
+func CurrentPublicKeys() ([]string, error) {
+	value := services.AdminKeyString(updatePublicKeysAdminKey)
+	if len(value) == 0 {
+		return DefaultSource.PublicKeys, nil
+	}
+	keys, err := parsePublicKeys(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s admin registry value: %w", updatePublicKeysAdminKey, err)
+	}
+	return keys, nil
+}
+
+// parsePublicKeys parses signify public keys, in base64, separated by commas or
+// spaces, of which there must be at least one.
+func parsePublicKeys(s string) ([]string, error) {
+	keys := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(keys) == 0 {
+		return nil, errors.New("No public keys are given")
+	}
+	for _, key := range keys {
+		if _, _, err := parsePublicKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
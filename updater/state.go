@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/amnezia-vpn/amneziawg-windows/conf"
+)
+
+// updaterState is what the updater learns from the update source and must
+// remember between checks, so that a replay of older files cannot undo it,
+// along with the chosen channel and any interrupted download. It is kept in a file next to the
+// configurations.
+type updaterState struct {
+	TrustedKeys []string           `json:"trusted_keys,omitempty"`
+	RevokedKeys []string           `json:"revoked_keys,omitempty"`
+	Serials     map[Channel]uint64 `json:"serials,omitempty"` // Highest serial of a signed list seen, by channel.
+	Channel     string             `json:"channel,omitempty"` // Chosen with SetChannel.
+
+	PartialDownload *partialDownload `json:"partial_download,omitempty"`
+
+	PendingInstall *pendingInstall `json:"pending_install,omitempty"`
+	InstallFailure *InstallFailure `json:"install_failure,omitempty"` // Last update that failed to install, until one is installed.
+	InstalledMsi   string          `json:"installed_msi,omitempty"`   // Version of the MSI kept as that installed.
+
+	save func(*updaterState) error // Persists the state once it changes, if set.
+}
+
+var updaterStateLock sync.Mutex
+
+func updaterStatePath(createRoot bool) (string, error) {
+	root, err := conf.RootDirectory(createRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "updater.json"), nil
+}
+
+func loadUpdaterState() (*updaterState, error) {
+	path, err := updaterStatePath(false)
+	if err != nil {
+		return nil, err
+	}
+	state := &updaterState{save: saveUpdaterState}
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func saveUpdaterState(state *updaterState) error {
+	path, err := updaterStatePath(true)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, bytes, 0o600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// keyring returns the keyring of the keys of source and those learned since,
+// without the revoked ones.
+func (state *updaterState) keyring(source *Source) (*keyring, error) {
+	return newKeyring(append(append([]string(nil), source.PublicKeys...), state.TrustedKeys...), state.RevokedKeys)
+}
+
+// learn remembers the keys that kr trusted or revoked by statements.
+func (state *updaterState) learn(kr *keyring) {
+	var trusted []string
+	for _, s := range append(state.TrustedKeys, kr.learned...) {
+		if id, _, err := parsePublicKey(s); err == nil && !kr.revoked[id] {
+			trusted = append(trusted, s)
+		}
+	}
+	state.TrustedKeys = trusted
+	state.RevokedKeys = kr.revokedIDs()
+}
+
+func (state *updaterState) persist() error {
+	if state.save == nil {
+		return nil
+	}
+	return state.save(state)
+}
@@ -0,0 +1,9 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+//sys	msiEnumRelatedProducts(upgradeCode *uint16, reserved uint32, index uint32, productCode *uint16) (ret error) = msi.MsiEnumRelatedProductsW
+//sys	msiGetProductInfo(product *uint16, property *uint16, value *uint16, valueLen *uint32) (ret error) = msi.MsiGetProductInfoW
@@ -0,0 +1,45 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// transport fetches files from the server of a source.
+type transport interface {
+	// Get requests path, bypassing caches if refresh.
+	Get(path string, refresh bool) (response, error)
+	// GetFrom requests the bytes of path from offset onward, which the server
+	// may ignore, responding with all of them and status 200 rather than 206.
+	GetFrom(path string, offset uint64) (response, error)
+	Close() error
+}
+
+type response interface {
+	io.ReadCloser
+	StatusCode() (uint32, error)
+	Length() (uint64, error)
+	// ContentRange returns the Content-Range header, of a 206 response.
+	ContentRange() (string, error)
+}
+
+// rangeStart returns the offset of the first byte of a partial response,
+// given its Content-Range, such as "bytes 100-199/200".
+func rangeStart(contentRange string) (uint64, error) {
+	rest, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("Invalid Content-Range %#q", contentRange)
+	}
+	start, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, fmt.Errorf("Invalid Content-Range %#q", contentRange)
+	}
+	return strconv.ParseUint(strings.TrimSpace(start), 10, 64)
+}
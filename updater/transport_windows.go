@@ -0,0 +1,76 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package updater
+
+import (
+	"github.com/amnezia-vpn/amneziawg-windows-client/updater/winhttp"
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
+)
+
+// dialTransport connects to the server of source, which is done with WinHTTP,
+// so that the system's proxy and certificate settings apply.
+var dialTransport = dialWinHTTP
+
+type winhttpTransport struct {
+	session    *winhttp.Session
+	connection *winhttp.Connection
+}
+
+func dialWinHTTP(source *Source) (transport, error) {
+	session, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	connection, err := session.Connect(source.Host, source.Port, source.UseHttps)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &winhttpTransport{session, connection}, nil
+}
+
+func (t *winhttpTransport) Get(path string, refresh bool) (response, error) {
+	r, err := t.connection.Get(path, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (t *winhttpTransport) GetFrom(path string, offset uint64) (response, error) {
+	r, err := t.connection.GetFrom(path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (t *winhttpTransport) Close() error {
+	t.connection.Close()
+	return t.session.Close()
+}
+
+func newSession() (*winhttp.Session, error) {
+	proxy, err := CurrentProxy()
+	if err != nil {
+		return nil, err
+	}
+	return winhttp.NewSessionWithProxy(version.UserAgent(), proxy)
+}
+
+// ProxyFor describes the proxy that the updater uses to reach the list of
+// channel at source, for logging.
+func ProxyFor(source *Source, channel Channel) (string, error) {
+	if len(source.Folder) > 0 {
+		return "no proxy, since the source is a folder", nil
+	}
+	session, err := newSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	return session.ProxyFor(source.URL(channel))
+}
@@ -6,36 +6,990 @@
 package updater
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/amnezia-vpn/amneziawg-windows-client/updater/winhttp"
+	"github.com/amnezia-vpn/amneziawg-windows-client/version"
 )
 
-func TestUpdate(t *testing.T) {
-	update, err := CheckForUpdate()
+func init() {
+	// The tests serve updates with net/http, so they use it to fetch them too.
+	dialTransport = dialHTTP
+}
+
+// testSigner signs lists of MSIs the way releases are signed with signify.
+type testSigner struct {
+	keyNum     [8]byte
+	privateKey ed25519.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	signer := new(testSigner)
+	rand.Read(signer.keyNum[:])
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.privateKey = privateKey
+	return signer
+}
+
+func (signer *testSigner) publicKey() string {
+	key := append([]byte("Ed"), signer.keyNum[:]...)
+	return base64.StdEncoding.EncodeToString(append(key, signer.privateKey.Public().(ed25519.PublicKey)...))
+}
+
+func (signer *testSigner) sign(message []byte) []byte {
+	signature := append([]byte("Ed"), signer.keyNum[:]...)
+	signature = append(signature, ed25519.Sign(signer.privateKey, message)...)
+	return fmt.Appendf(nil, "untrusted comment: test\n%s\n%s", base64.StdEncoding.EncodeToString(signature), message)
+}
+
+func (signer *testSigner) signList(msis map[string][]byte) []byte {
+	return signer.signListWithSerial(1, msis)
+}
+
+func (signer *testSigner) signListWithSerial(serial uint64, msis map[string][]byte) []byte {
+	now := time.Now().UTC()
+	list := fmt.Appendf(nil, "trusted comment: serial=%d issued=%s expires=%s\n", serial, now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour*24*30).Format(time.RFC3339))
+	for name, contents := range msis {
+		hash := blake2b.Sum256(contents)
+		list = fmt.Appendf(list, "%s  %s\n", hex.EncodeToString(hash[:]), name)
+	}
+	return signer.sign(list)
+}
+
+func (signer *testSigner) trust(other *testSigner) []byte {
+	return signer.sign([]byte("trust " + other.publicKey() + "\n"))
+}
+
+func (signer *testSigner) revoke(other *testSigner) []byte {
+	return signer.sign(fmt.Appendf(nil, "revoke %x\n", other.keyNum))
+}
+
+// serveTestMirror serves files from a directory of a local HTTP server, and
+// returns a source for it trusting signer.
+func serveTestMirror(t *testing.T, signer *testSigner, files map[string][]byte) *Source {
+	mux := http.NewServeMux()
+	for name, contents := range files {
+		mux.HandleFunc("/mirror/"+name, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(contents)
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	source, err := DefaultSource.WithMirror(server.URL + "/mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.PublicKeys = []string{signer.publicKey()}
+	return source
+}
+
+func TestSourceWithMirror(t *testing.T) {
+	tests := []struct {
+		mirror   string
+		host     string
+		port     uint16
+		https    bool
+		listPath string
+	}{
+		{"https://mirror.example.com/amneziawg/", "mirror.example.com", 443, true, "/amneziawg/latest.sig"},
+		{"http://10.0.0.1:8080/releases", "10.0.0.1", 8080, false, "/releases/latest.sig"},
+		{"HTTPS://[fd00::1]", "fd00::1", 443, true, "/latest.sig"},
+		{"ftp://mirror.example.com/", "", 0, false, ""},
+		{"https://user@mirror.example.com/", "", 0, false, ""},
+		{"https://mirror.example.com:0/", "", 0, false, ""},
+		{"/amneziawg/", "", 0, false, ""},
+	}
+	for _, test := range tests {
+		source, err := DefaultSource.WithMirror(test.mirror)
+		if len(test.host) == 0 {
+			if err == nil {
+				t.Errorf("Mirror %#q was accepted", test.mirror)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Mirror %#q was rejected: %v", test.mirror, err)
+			continue
+		}
+		if source.Host != test.host || source.Port != test.port || source.UseHttps != test.https || source.listPath(ChannelStable) != test.listPath {
+			t.Errorf("Mirror %#q gave %s:%d https=%v %#q", test.mirror, source.Host, source.Port, source.UseHttps, source.listPath(ChannelStable))
+		}
+		if beta := strings.TrimSuffix(test.listPath, listName) + "beta/" + listName; source.listPath(ChannelBeta) != beta {
+			t.Errorf("Mirror %#q gave %#q for beta", test.mirror, source.listPath(ChannelBeta))
+		}
+		if source.MsiPrefix != DefaultSource.MsiPrefix || len(source.PublicKeys) != len(DefaultSource.PublicKeys) {
+			t.Errorf("Mirror %#q changed the naming or keys", test.mirror)
+		}
+	}
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	first, second := newTestSigner(t).publicKey(), newTestSigner(t).publicKey()
+	keys, err := parsePublicKeys(first + ", " + second)
+	if err != nil || len(keys) != 2 || keys[0] != first || keys[1] != second {
+		t.Errorf("Expected two keys, but got %v, %v", keys, err)
+	}
+	if _, err := parsePublicKeys(releasePublicKeyBase64); err != nil {
+		t.Errorf("Release key was rejected: %v", err)
+	}
+	for _, invalid := range []string{"", " , ", first + ",RWQnotakey"} {
+		if _, err := parsePublicKeys(invalid); err == nil {
+			t.Errorf("Keys %#q were accepted", invalid)
+		}
+	}
+}
+
+func TestSourceWithFolder(t *testing.T) {
+	folder := t.TempDir()
+	source, err := DefaultSource.WithFolder(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.listPath(ChannelStable) != filepath.Join(folder, listName) || source.listPath(ChannelNightly) != filepath.Join(folder, "nightly", listName) {
+		t.Errorf("Folder %#q gave %#q and %#q", folder, source.listPath(ChannelStable), source.listPath(ChannelNightly))
+	}
+	if source.KeysPath != filepath.Join(folder, keysName) || source.msiPath(ChannelBeta, "a b.msi") != filepath.Join(folder, "beta", "a b.msi") {
+		t.Errorf("Folder %#q gave %#q and %#q", folder, source.KeysPath, source.msiPath(ChannelBeta, "a b.msi"))
+	}
+	if source.URL(ChannelStable) != source.listPath(ChannelStable) || len(source.Host) > 0 {
+		t.Errorf("Folder %#q is described as %#q on %#q", folder, source.URL(ChannelStable), source.Host)
+	}
+	for _, invalid := range []string{"", "updates", filepath.Join("..", "updates")} {
+		if _, err := DefaultSource.WithFolder(invalid); err == nil {
+			t.Errorf("Folder %#q was accepted", invalid)
+		}
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	old, next, attacker := newTestSigner(t), newTestSigner(t), newTestSigner(t)
+	state := new(updaterState)
+	kr, err := state.keyring(&Source{PublicKeys: []string{old.publicKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := next.signList(map[string][]byte{"a.msi": nil})
+	if _, _, err = readFileList(list, kr); err == nil {
+		t.Fatal("List signed by a key not yet trusted was accepted")
+	}
+	if err = kr.applyStatements(attacker.trust(attacker)); err == nil {
+		t.Fatal("Statement by an untrusted key was applied")
+	}
+	statements := append(old.trust(next), next.revoke(old)...)
+	if err = kr.applyStatements(statements); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = readFileList(list, kr); err != nil {
+		t.Fatalf("List signed by the rotated key was rejected: %v", err)
+	}
+	if _, _, err = readFileList(old.signList(map[string][]byte{"a.msi": nil}), kr); err == nil {
+		t.Fatal("List signed by a revoked key was accepted")
+	}
+	state.learn(kr)
+
+	// After restarting, a replay of the rotation must neither fail nor bring
+	// back the revoked key.
+	kr, err = state.keyring(&Source{PublicKeys: []string{old.publicKey()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kr.applyStatements(old.trust(old)); err != nil {
+		t.Fatal(err)
+	}
+	if err = kr.applyStatements(statements); err != nil {
+		t.Fatal(err)
+	}
+	if kr.changed {
+		t.Error("Replayed statements changed the keyring")
+	}
+	if _, _, err = readFileList(list, kr); err != nil {
+		t.Errorf("List signed by the learned key was rejected: %v", err)
+	}
+	if _, err = kr.find(keyID(old.keyNum)); err == nil {
+		t.Error("Revoked key is trusted again")
+	}
+}
+
+func TestListFreshness(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		comment string
+		highest uint64
+		valid   bool
+	}{
+		{"trusted comment: serial=7 issued=2026-09-30T00:00:00Z expires=2026-10-30T00:00:00Z", 6, true},
+		{"trusted comment: serial=7 issued=2026-09-30T00:00:00Z expires=2026-10-30T00:00:00Z", 7, true},
+		{"trusted comment: serial=7 issued=2026-09-30T00:00:00Z expires=2026-10-30T00:00:00Z", 8, false},
+		{"trusted comment: serial=7 issued=2026-08-01T00:00:00Z expires=2026-09-01T00:00:00Z", 0, false},
+		{"trusted comment: serial=7 issued=2026-09-30T00:00:00Z", 0, false},
+		{"trusted comment: serial=0 issued=2026-09-30T00:00:00Z expires=2026-10-30T00:00:00Z", 0, false},
+		{"trusted comment: serial=7 issued=2026-10-30T00:00:00Z expires=2026-09-30T00:00:00Z", 0, false},
+		{"trusted comment: serial=x issued=2026-09-30T00:00:00Z expires=2026-10-30T00:00:00Z", 0, false},
+		{"serial=7 issued=2026-09-30T00:00:00Z expires=2026-10-30T00:00:00Z", 0, false},
+	}
+	for _, test := range tests {
+		header, err := parseListHeader(test.comment)
+		var serial uint64
+		if err == nil {
+			serial, err = header.checkFresh(now, test.highest)
+		}
+		if test.valid && (err != nil || serial != 7) {
+			t.Errorf("%#q with highest serial %d was rejected: %v", test.comment, test.highest, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%#q with highest serial %d was accepted", test.comment, test.highest)
+		}
+	}
+}
+
+func TestFindCandidate(t *testing.T) {
+	prefix := fmt.Sprintf(DefaultSource.MsiPrefix, version.Arch())
+	tests := []struct {
+		versions []string
+		want     string
+	}{
+		{[]string{"0.5", version.Number}, ""},
+		{[]string{"99.0", "99.0.1", "98.9"}, "99.0.1"},
+		{[]string{"99.0-rc1", "99.0-rc.2", "99.0-beta"}, "99.0-rc1"},
+		{[]string{"99.0-rc1", "99.0"}, "99.0"},
+		{[]string{"99.0+build.1", version.Number + "+build.2"}, "99.0+build.1"},
+		{[]string{"99.0", "99.0-rc.x_1"}, "99.0"},
+		{[]string{"99.0+build.1", "99.0+build.2", "99.0+build.10"}, "99.0+build.2"},
+		{[]string{"99.1+" + strings.Repeat("x", 128), "99.0"}, "99.0"},
+	}
+	for _, test := range tests {
+		files := make(fileList)
+		for _, v := range test.versions {
+			files[prefix+v+DefaultSource.MsiSuffix] = [blake2b.Size256]byte{}
+		}
+		files["amneziawg-other-100.0.msi"] = [blake2b.Size256]byte{}
+		update, err := findCandidate(files, &DefaultSource, ChannelBeta)
+		var got string
+		if err != nil {
+			got = "error"
+		} else if update != nil {
+			got = update.Version().String()
+		}
+		if got != test.want {
+			t.Errorf("Candidates %q gave %#q, but expected %#q", test.versions, got, test.want)
+		}
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, err := time.ParseInLocation("15:04", clock, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		window  string
+		inside  []string
+		outside []string
+	}{
+		{"03:00-05:00", []string{"03:00", "04:59"}, []string{"02:59", "05:00", "12:00"}},
+		{"22:30-02:00", []string{"22:30", "23:59", "00:00", "01:59"}, []string{"22:29", "02:00", "12:00"}},
+		{" 00:00 - 23:59 ", []string{"00:00", "12:00", "23:58"}, []string{"23:59"}},
+	}
+	for _, test := range tests {
+		window, err := ParseMaintenanceWindow(test.window)
+		if err != nil {
+			t.Errorf("%#q was rejected: %v", test.window, err)
+			continue
+		}
+		for _, clock := range test.inside {
+			if !window.Contains(at(clock)) {
+				t.Errorf("%v does not contain %s", window, clock)
+			}
+		}
+		for _, clock := range test.outside {
+			if window.Contains(at(clock)) {
+				t.Errorf("%v contains %s", window, clock)
+			}
+		}
+	}
+	for _, invalid := range []string{"", "03:00", "3:00-5:00", "03:00-24:00", "03:60-05:00", "03:00-03:00", "0a:00-05:00", "03:00-05:00-07:00"} {
+		if window, err := ParseMaintenanceWindow(invalid); err == nil {
+			t.Errorf("%#q was accepted as %v", invalid, window)
+		}
+	}
+	if DefaultMaintenanceWindow.String() != "03:00-05:00" {
+		t.Errorf("Default window formats as %#q", DefaultMaintenanceWindow.String())
+	}
+	for _, policy := range []Policy{PolicyDisabled, PolicyNotify, PolicyDownload, PolicyInstall} {
+		if parsed, err := ParsePolicy(strings.ToUpper(policy.String())); err != nil || parsed != policy {
+			t.Errorf("Policy %v parses as %v: %v", policy, parsed, err)
+		}
+	}
+}
+
+func TestCheckSourceForUpdate(t *testing.T) {
+	signer := newTestSigner(t)
+	prefix := fmt.Sprintf(DefaultSource.MsiPrefix, version.Arch())
+	newer := prefix + "99.0" + DefaultSource.MsiSuffix
+	msis := map[string][]byte{
+		prefix + "0.1" + DefaultSource.MsiSuffix: []byte("old"),
+		"amneziawg-other-99.0.msi":               []byte("other"),
+		newer:                                    []byte("new"),
+	}
+	source := serveTestMirror(t, signer, map[string][]byte{"latest.sig": signer.signList(msis)})
+	update, _, err := checkSourceForUpdate(source, ChannelStable, new(updaterState), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update == nil || update.name != newer || update.hash != blake2b.Sum256(msis[newer]) {
+		t.Fatalf("Expected %#q, but found %v", newer, update)
+	}
+
+	source.PublicKeys = []string{newTestSigner(t).publicKey()}
+	if _, _, err = checkSourceForUpdate(source, ChannelStable, new(updaterState), false); err == nil {
+		t.Error("List signed by an untrusted key was accepted")
+	}
+
+	next := newTestSigner(t)
+	source = serveTestMirror(t, signer, map[string][]byte{"latest.sig": next.signList(msis), "keys.sig": signer.trust(next)})
+	state := new(updaterState)
+	if _, _, err = checkSourceForUpdate(source, ChannelStable, state, false); err != nil {
+		t.Fatalf("List signed by a rotated key was rejected: %v", err)
+	}
+	if len(state.TrustedKeys) != 1 || state.TrustedKeys[0] != next.publicKey() {
+		t.Errorf("Rotated key was not remembered: %q", state.TrustedKeys)
+	}
+
+	state = new(updaterState)
+	source = serveTestMirror(t, signer, map[string][]byte{"latest.sig": signer.signListWithSerial(5, msis)})
+	if _, _, err = checkSourceForUpdate(source, ChannelStable, state, false); err != nil || state.Serials[ChannelStable] != 5 {
+		t.Fatalf("Serial 5 was not remembered: %v, %v", state.Serials, err)
+	}
+	source = serveTestMirror(t, signer, map[string][]byte{"latest.sig": signer.signListWithSerial(4, msis)})
+	if _, _, err = checkSourceForUpdate(source, ChannelStable, state, false); err == nil {
+		t.Error("List with an older serial was accepted")
+	}
+
+	delete(msis, newer)
+	source = serveTestMirror(t, signer, map[string][]byte{"latest.sig": signer.signList(msis)})
+	update, _, err = checkSourceForUpdate(source, ChannelStable, new(updaterState), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update != nil {
+		t.Errorf("Found %#q, which is not newer", update.name)
+	}
+
+	beta := prefix + "99.1" + DefaultSource.MsiSuffix
+	source = serveTestMirror(t, signer, map[string][]byte{
+		"latest.sig":      signer.signListWithSerial(9, msis),
+		"beta/latest.sig": signer.signListWithSerial(2, map[string][]byte{beta: []byte("beta")}),
+	})
+	state = new(updaterState)
+	update, _, err = checkSourceForUpdate(source, ChannelBeta, state, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update == nil || update.name != beta || update.channel != ChannelBeta || source.msiPath(update.channel, update.name) != "/mirror/beta/"+beta {
+		t.Fatalf("Expected %#q in beta, but found %v", beta, update)
+	}
+	if update, _, err = checkSourceForUpdate(source, ChannelStable, state, false); err != nil || update != nil {
+		t.Errorf("Switching back to stable found %v, %v", update, err)
+	}
+	if state.Serials[ChannelBeta] != 2 || state.Serials[ChannelStable] != 9 {
+		t.Errorf("Serials were not remembered by channel: %v", state.Serials)
+	}
+}
+
+func TestCheckFolderForUpdate(t *testing.T) {
+	signer := newTestSigner(t)
+	newer := fmt.Sprintf(DefaultSource.MsiPrefix, version.Arch()) + "99.0" + DefaultSource.MsiSuffix
+	msi := bytes.Repeat([]byte("offline"), 4096)
+	folder := t.TempDir()
+	issued := time.Now().UTC().Add(-time.Hour * 24 * 60)
+	list := fmt.Appendf(nil, "trusted comment: serial=3 issued=%s expires=%s\n", issued.Format(time.RFC3339), issued.Add(time.Hour*24*30).Format(time.RFC3339))
+	hash := blake2b.Sum256(msi)
+	list = fmt.Appendf(list, "%s  %s\n", hex.EncodeToString(hash[:]), newer)
+	for name, contents := range map[string][]byte{listName: signer.sign(list), newer: msi} {
+		if err := os.WriteFile(filepath.Join(folder, name), contents, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source, err := DefaultSource.WithFolder(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.PublicKeys = []string{signer.publicKey()}
+	state := new(updaterState)
+	update, tr, err := checkSourceForUpdate(source, ChannelStable, state, true)
+	if err != nil {
+		t.Fatalf("Expired list in a folder was rejected: %v", err)
+	}
+	if tr != nil {
+		t.Error("Folder was read through a connection")
+	}
+	if update == nil || update.name != newer || state.Serials[ChannelStable] != 3 {
+		t.Fatalf("Expected %#q with serial 3, but found %v with %v", newer, update, state.Serials)
+	}
+	state.Serials[ChannelStable] = 4
+	if _, _, err = checkSourceForUpdate(source, ChannelStable, state, false); err == nil {
+		t.Error("List in a folder with an older serial was accepted")
+	}
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "copy.msi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dl, err := newDownload(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl.Write([]byte("stale"))
+	if err = dl.copyFrom(source.msiPath(update.channel, update.name), drainProgress(t)); err != nil {
+		t.Fatal(err)
+	}
+	if dl.length != uint64(len(msi)) || !bytes.Equal(dl.hasher.Sum(nil), hash[:]) {
+		t.Errorf("Copied %d bytes with the wrong hash", dl.length)
+	}
+}
+
+func TestReleaseNotes(t *testing.T) {
+	signer := newTestSigner(t)
+	v, _ := version.Parse("99.0")
+	newer := fmt.Sprintf(DefaultSource.MsiPrefix, version.Arch()) + v.String() + DefaultSource.MsiSuffix
+	notes := []byte(`{"version": "99.0", "date": "2026-10-01", "severity": "Security", "changelog": "Fixes a flaw.\r\nAdds a feature.\r\n"}`)
+	folder := t.TempDir()
+	writeRelease := func(listed, served []byte) {
+		msiHash, notesHash := blake2b.Sum256([]byte("msi")), blake2b.Sum256(listed)
+		issued := time.Now().UTC()
+		list := fmt.Appendf(nil, "trusted comment: serial=1 issued=%s expires=%s\n", issued.Format(time.RFC3339), issued.Add(time.Hour).Format(time.RFC3339))
+		list = fmt.Appendf(list, "%s  %s\n%s  %s\n", hex.EncodeToString(msiHash[:]), newer, hex.EncodeToString(notesHash[:]), releaseNotesName(v))
+		for name, contents := range map[string][]byte{listName: signer.sign(list), releaseNotesName(v): served} {
+			if err := os.WriteFile(filepath.Join(folder, name), contents, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	source, err := DefaultSource.WithFolder(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.PublicKeys = []string{signer.publicKey()}
+
+	writeRelease(notes, notes)
+	update, _, err := checkSourceForUpdate(source, ChannelStable, new(updaterState), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := update.Notes()
+	want := &ReleaseNotes{"99.0", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), SeveritySecurity, "Fixes a flaw.\nAdds a feature."}
+	if err != nil || got == nil || *got != *want {
+		t.Errorf("Expected %+v, but found %+v with %v", want, got, err)
+	}
+
+	writeRelease(notes, bytes.Replace(notes, []byte("Security"), []byte("routine "), 1))
+	update, _, err = checkSourceForUpdate(source, ChannelStable, new(updaterState), false)
+	if err != nil {
+		t.Fatalf("Update with altered notes was rejected: %v", err)
+	}
+	if got, err = update.Notes(); got != nil || err == nil {
+		t.Errorf("Altered notes were accepted: %+v", got)
+	}
+
+	for _, input := range []string{
+		`{"version": "98.0", "date": "2026-10-01", "severity": "routine"}`,
+		`{"version": "99.0+other", "date": "2026-10-01", "severity": "routine"}`,
+		`{"version": "99.0", "date": "October 1st", "severity": "routine"}`,
+		`{"version": "99.0", "date": "2026-10-01", "severity": "urgent"}`,
+		`{"version": "99.0", "date": "2026-10-01", "severity": "routine"`,
+	} {
+		if notes, err := parseReleaseNotes([]byte(input), v); err == nil {
+			t.Errorf("Invalid notes %#q were parsed as %+v", input, notes)
+		}
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		input string
+		want  winhttp.Proxy
+		valid bool
+	}{
+		{"", winhttp.Proxy{}, true},
+		{"DIRECT", winhttp.Proxy{Direct: true}, true},
+		{"auto", winhttp.Proxy{AutoDetect: true}, true},
+		{"proxy.example.com:3128", winhttp.Proxy{Server: "proxy.example.com:3128"}, true},
+		{"[fd00::1]:8080", winhttp.Proxy{Server: "[fd00::1]:8080"}, true},
+		{"http://wpad.example.com/proxy.pac", winhttp.Proxy{PacURL: "http://wpad.example.com/proxy.pac"}, true},
+		{"proxy.example.com", winhttp.Proxy{}, false},
+		{":3128", winhttp.Proxy{}, false},
+		{"proxy.example.com:0", winhttp.Proxy{}, false},
+		{"proxy.example.com:http", winhttp.Proxy{}, false},
+		{"file:///C:/proxy.pac", winhttp.Proxy{}, false},
+		{"socks5://proxy.example.com:1080", winhttp.Proxy{}, false},
+	}
+	for _, test := range tests {
+		proxy, err := parseProxy(test.input)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%#q was accepted as %v", test.input, proxy)
+			}
+		} else if err != nil {
+			t.Errorf("%#q was rejected: %v", test.input, err)
+		} else if proxy != test.want {
+			t.Errorf("%#q gave %v, but expected %v", test.input, proxy, test.want)
+		}
+	}
+}
+
+// serveFlakyMsi serves msi from a local HTTP server, dropping the connection
+// halfway through the first response, and honoring ranges unless ignoreRange.
+func serveFlakyMsi(t *testing.T, msi []byte, ignoreRange bool) (transport, *atomic.Int32) {
+	requests := new(atomic.Int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(msi)))
+			w.Write(msi[:len(msi)/2])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		if ignoreRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "test.msi", time.Time{}, bytes.NewReader(msi))
+	}))
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	tr, err := dialTransport(&Source{Host: u.Hostname(), Port: uint16(port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, requests
+}
+
+func drainProgress(t *testing.T) chan DownloadProgress {
+	progress := make(chan DownloadProgress, 128)
+	go func() {
+		for dp := range progress {
+			if len(dp.Activity) > 0 {
+				t.Log(dp.Activity)
+			}
+		}
+	}()
+	t.Cleanup(func() { close(progress) })
+	return progress
+}
+
+func TestThroughputMeter(t *testing.T) {
+	start := time.Now()
+	m := newThroughputMeter(start, 1000)
+	if m.sample(start.Add(progressInterval/2), 2000) {
+		t.Error("Sampled before the progress interval passed")
+	}
+	if !m.sample(start.Add(time.Second), 1000+100*1024) || m.bytesPerSecond() != 100*1024 {
+		t.Errorf("Expected 100 KiB/s, but found %d B/s", m.bytesPerSecond())
+	}
+	if remaining := m.remaining(1000+100*1024, 1000+1000*1024); remaining != time.Second*9 {
+		t.Errorf("Expected 9s remaining, but found %v", remaining)
+	}
+	m.sample(start.Add(time.Second*2), 1000+300*1024)
+	if rate := m.bytesPerSecond(); rate <= 100*1024 || rate >= 200*1024 {
+		t.Errorf("Expected the throughput to be smoothed, but found %d B/s", rate)
+	}
+	if remaining := m.remaining(10, 10); remaining != 0 {
+		t.Errorf("Expected nothing remaining, but found %v", remaining)
+	}
+}
+
+func TestProgressRateLimit(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.msi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dl, err := newDownload(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const total = 1024 * 1024
+	progress := make(chan DownloadProgress, 128)
+	dp := DownloadProgress{Activity: "Downloading update", BytesTotal: total}
+	pm := newProgressHashWatcher(&dp, progress, dl)
+	for range total / 1024 {
+		pm.Write(make([]byte, 1024))
+	}
+	close(progress)
+	var events []DownloadProgress
+	for event := range progress {
+		events = append(events, event)
+	}
+	if len(events) == 0 || len(events) > 8 {
+		t.Fatalf("Expected a few progress events, but found %d", len(events))
+	}
+	if last := events[len(events)-1]; last.BytesDownloaded != total || len(last.Activity) > 0 {
+		t.Errorf("Expected the last event to report completion without repeating the activity, but found %+v", last)
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	msi := make([]byte, 1024*1024)
+	rand.Read(msi)
+	for _, ignoreRange := range []bool{false, true} {
+		tr, requests := serveFlakyMsi(t, msi, ignoreRange)
+		file, err := os.Create(filepath.Join(t.TempDir(), "test.msi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		dl, err := newDownload(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dl.fetch(tr, "/test.msi", drainProgress(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requests.Load() != 2 {
+			t.Errorf("Expected a retry, but made %d requests", requests.Load())
+		}
+		if dl.length != uint64(len(msi)) || !bytes.Equal(dl.hasher.Sum(nil), hashOf(msi)) {
+			t.Errorf("Download with ignoreRange=%v has %d bytes and the wrong hash", ignoreRange, dl.length)
+		}
+		written, _ := os.ReadFile(file.Name())
+		if !bytes.Equal(written, msi) {
+			t.Errorf("Download with ignoreRange=%v wrote the wrong bytes", ignoreRange)
+		}
+	}
+}
+
+func TestResumePartialDownload(t *testing.T) {
+	msi := make([]byte, 1024*1024)
+	rand.Read(msi)
+	update := &UpdateFound{name: "test.msi", hash: blake2b.Sum256(msi)}
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.msi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dl, err := newDownload(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl.Write(msi[:300000])
+	partial, err := dl.partial(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("bytes written after the state was saved"))
+
+	dl, err = resumeDownload(file, partial)
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	if update == nil {
-		t.Error("No update available")
-		return
+	tr, requests := serveFlakyMsi(t, msi, false)
+	requests.Store(1)
+	err = dl.fetch(tr, "/test.msi", drainProgress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dl.hasher.Sum(nil), update.hash[:]) {
+		t.Error("Resumed download has the wrong hash")
 	}
-	t.Log("Found update")
-	progress := DownloadVerifyAndExecute(0)
-	for {
-		dp := <-progress
-		if dp.Error != nil {
-			t.Error(dp.Error)
+	partial.Length = uint64(len(msi)) * 2
+	if _, err = resumeDownload(file, partial); err == nil {
+		t.Error("Resumed a partial download longer than its file")
+	}
+}
+
+func TestResumeAtWrongOffset(t *testing.T) {
+	msi := make([]byte, 1024*1024)
+	rand.Read(msi)
+	requests := new(atomic.Int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if len(r.Header.Get("Range")) > 0 {
+			// Claims to resume, but sends everything from the start.
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(msi)-1, len(msi)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(msi)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	tr, err := dialTransport(&Source{Host: u.Hostname(), Port: uint16(port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	savedMinRetryDelay := minRetryDelay
+	minRetryDelay = time.Millisecond
+	defer func() { minRetryDelay = savedMinRetryDelay }()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.msi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	dl, err := newDownload(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl.Write(msi[:300000])
+	err = dl.fetch(tr, "/test.msi", drainProgress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected the download to start over, but made %d requests", requests.Load())
+	}
+	written, _ := os.ReadFile(file.Name())
+	if !bytes.Equal(written, msi) || !bytes.Equal(dl.hasher.Sum(nil), hashOf(msi)) {
+		t.Error("Download resumed at the wrong offset wrote the wrong bytes")
+	}
+}
+
+// serveTestRelease serves list as the signed list of a local HTTP server,
+// and the MSIs in it with serveMsi, and returns a source for it trusting
+// signer.
+func serveTestRelease(t *testing.T, signer *testSigner, list []byte, serveMsi http.HandlerFunc) *Source {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mirror/latest.sig", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(list)
+	})
+	mux.HandleFunc("/mirror/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, DefaultSource.MsiSuffix) {
+			http.NotFound(w, r)
 			return
 		}
-		if len(dp.Activity) > 0 {
-			t.Log(dp.Activity)
+		serveMsi(w, r)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	source, err := DefaultSource.WithMirror(server.URL + "/mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.PublicKeys = []string{signer.publicKey()}
+	return source
+}
+
+func TestEndToEnd(t *testing.T) {
+	savedMinRetryDelay, savedMaxRetryDelay := minRetryDelay, maxRetryDelay
+	minRetryDelay, maxRetryDelay = time.Millisecond, time.Millisecond*10
+	defer func() { minRetryDelay, maxRetryDelay = savedMinRetryDelay, savedMaxRetryDelay }()
+
+	signer := newTestSigner(t)
+	name := fmt.Sprintf(DefaultSource.MsiPrefix, version.Arch()) + "99.0" + DefaultSource.MsiSuffix
+	msi := make([]byte, 256*1024)
+	rand.Read(msi)
+	list := signer.signList(map[string][]byte{name: msi})
+	tamperedList := bytes.Replace(list, []byte(hex.EncodeToString(hashOf(msi))), []byte(hex.EncodeToString(hashOf(nil))), 1)
+	tamperedMsi := bytes.Clone(msi)
+	tamperedMsi[len(msi)/3] ^= 1
+
+	serve := func(msi []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(msi))
+		}
+	}
+	// A truncated response of known length ends early, while one of unknown
+	// length ends as if complete.
+	truncate := func(withLength bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !withLength {
+				w.(http.Flusher).Flush()
+				w.Write(msi[:len(msi)/2])
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(msi)))
+			w.Write(msi[:len(msi)/2])
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
 		}
-		if dp.BytesTotal > 0 {
-			t.Logf("Downloaded %d of %d", dp.BytesDownloaded, dp.BytesTotal)
+	}
+
+	tests := []struct {
+		name     string
+		list     []byte
+		serveMsi http.HandlerFunc
+		failsAt  string
+	}{
+		{"valid", list, serve(msi), ""},
+		{"signed by an untrusted key", newTestSigner(t).signList(map[string][]byte{name: msi}), serve(msi), "check"},
+		{"list altered after signing", tamperedList, serve(msi), "check"},
+		{"msi altered", list, serve(tamperedMsi), "hash"},
+		{"msi missing", list, http.NotFound, "download"},
+		{"truncated download", list, truncate(true), "download"},
+		{"truncated download of unknown length", list, truncate(false), "hash"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := serveTestRelease(t, signer, test.list, test.serveMsi)
+			failsAt := func() string {
+				update, tr, err := checkSourceForUpdate(source, ChannelStable, new(updaterState), true)
+				if err != nil {
+					t.Log(err)
+					return "check"
+				}
+				defer tr.Close()
+				if update == nil || update.name != name {
+					t.Fatalf("Expected %#q, but found %v", name, update)
+				}
+				file, err := os.Create(filepath.Join(t.TempDir(), "update.msi"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				dl, err := newDownload(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = dl.fetch(tr, source.msiPath(update.channel, update.name), drainProgress(t))
+				if err != nil {
+					t.Log(err)
+					return "download"
+				}
+				if !dl.matches(update) {
+					return "hash"
+				}
+				written, _ := os.ReadFile(file.Name())
+				if !bytes.Equal(written, msi) {
+					t.Error("Download has the right hash, but the wrong bytes")
+				}
+				return ""
+			}()
+			if failsAt != test.failsAt {
+				t.Errorf("Update failed at %#q, but expected %#q", failsAt, test.failsAt)
+			}
+		})
+	}
+}
+
+func hashOf(b []byte) []byte {
+	hash := blake2b.Sum256(b)
+	return hash[:]
+}
+
+func TestRecoverFailedInstall(t *testing.T) {
+	root := t.TempDir()
+	var reinstalled []string
+	var reinstallErr error
+	reinstall = func(path, productCode string, userToken uintptr) error {
+		contents, _ := os.ReadFile(path)
+		reinstalled = append(reinstalled, productCode+" "+string(contents))
+		return reinstallErr
+	}
+	t.Cleanup(func() { reinstall = reinstallMsi })
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(root, name))
+		return err == nil
+	}
+	pendingInstall := func(kept bool) *updaterState {
+		for name, contents := range map[string]string{previousMsiName: "1.0", nextMsiName: "2.0"} {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
 		}
-		if dp.Complete {
-			t.Log("Complete!")
-			break
+		return &updaterState{PendingInstall: &pendingInstall{From: "1.0", To: "2.0", ProductCode: "{P}", Previous: kept, Next: true}}
+	}
+
+	state := pendingInstall(true)
+	state.InstallFailure = &InstallFailure{From: "1.0", To: "1.5"}
+	failure, err := recoverFailedInstall(state, root, "2.0.0", 0)
+	if failure != nil || err != nil || state.PendingInstall != nil || state.InstallFailure != nil {
+		t.Errorf("Successful install was taken for a failure: %v, %v", failure, err)
+	}
+	if state.InstalledMsi != "2.0" || !exists(installedMsiName) || exists(previousMsiName) || exists(nextMsiName) {
+		t.Errorf("Expected the MSI of 2.0 to be kept as installed, but found %#q", state.InstalledMsi)
+	}
+
+	state = pendingInstall(true)
+	failure, err = recoverFailedInstall(state, root, "1.0.0+build.1", 0)
+	if err != nil || failure == nil || !failure.RolledBack || state.InstallFailure != failure {
+		t.Fatalf("Expected a rolled back failure, but found %+v with %v", failure, err)
+	}
+	if len(reinstalled) != 1 || reinstalled[0] != "{P} 1.0" || state.InstalledMsi != "1.0" || exists(nextMsiName) {
+		t.Errorf("Expected 1.0 to be reinstalled, but found %q", reinstalled)
+	}
+
+	reinstallErr = errors.New("msiexec failed")
+	state = pendingInstall(true)
+	failure, err = recoverFailedInstall(state, root, "1.0", 0)
+	if err == nil || failure == nil || failure.RolledBack {
+		t.Errorf("Failed rollback was reported as rolled back: %+v", failure)
+	}
+
+	reinstalled = nil
+	state = pendingInstall(false)
+	failure, err = recoverFailedInstall(state, root, "1.0", 0)
+	if err == nil || failure == nil || failure.RolledBack || len(reinstalled) != 0 {
+		t.Errorf("Version that was not kept was reinstalled: %+v", failure)
+	}
+
+	state = pendingInstall(true)
+	if failure, err = recoverFailedInstall(state, root, "3.0", 0); failure != nil || err != nil || len(reinstalled) != 0 {
+		t.Errorf("Version installed since was taken for a failure: %+v, %v", failure, err)
+	}
+
+	reinstallErr = nil
+	state = pendingInstall(true)
+	if failure, err = recoverFailedInstall(state, root, "", 0); err != nil || failure == nil || !failure.RolledBack || len(reinstalled) != 1 {
+		t.Errorf("Product left uninstalled was not reinstalled: %+v, %v", failure, err)
+	}
+
+	state = new(updaterState)
+	if failure, err = recoverFailedInstall(state, root, "1.0", 0); failure != nil || err != nil || exists(previousMsiName) || exists(nextMsiName) {
+		t.Errorf("Nothing pending was taken for a failure, or left stale MSIs: %v, %v", failure, err)
+	}
+}
+
+func TestMsiexecRebootRequired(t *testing.T) {
+	for _, code := range []uint32{0, msiexecRebootRequired, msiexecRebootInitiated} {
+		if err := msiexecResult(code); err != nil {
+			t.Errorf("Exit code %d was taken for a failure: %v", code, err)
 		}
 	}
+	if err := msiexecResult(1603); err == nil || msiChangedNothing(err) {
+		t.Errorf("Fatal error during installation was taken for %v", err)
+	}
+	if err := msiexecResult(msiexecUserExit); !msiChangedNothing(err) {
+		t.Errorf("Cancelled install was taken for one that changed something: %v", err)
+	}
+
+	// Once restarted, the update that needed the restart is installed, though
+	// the version that started installing it was the one it replaced.
+	reinstall = func(path, productCode string, userToken uintptr) error {
+		t.Error("Update that needed a restart was rolled back")
+		return nil
+	}
+	t.Cleanup(func() { reinstall = reinstallMsi })
+	state := &updaterState{PendingInstall: &pendingInstall{From: "1.0", To: "2.0", ProductCode: "{P}", Previous: true}}
+	if failure, err := recoverFailedInstall(state, t.TempDir(), "2.0", 0); failure != nil || err != nil || state.PendingInstall != nil {
+		t.Errorf("Update that needed a restart was taken for a failure: %+v, %v", failure, err)
+	}
 }
@@ -6,70 +6,45 @@
 package updater
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
+	"log"
 	"strings"
 
 	"github.com/amnezia-vpn/amneziawg-windows-client/version"
 )
 
-func versionNewerThanUs(candidate string) (bool, error) {
-	candidateParts := strings.Split(candidate, ".")
-	ourParts := strings.Split(version.Number, ".")
-	if len(candidateParts) == 0 || len(ourParts) == 0 {
-		return false, errors.New("Empty version")
-	}
-	l := len(candidateParts)
-	if len(ourParts) > l {
-		l = len(ourParts)
-	}
-	for i := 0; i < l; i++ {
-		var err error
-		cP, oP := uint64(0), uint64(0)
-		if i < len(candidateParts) {
-			if len(candidateParts[i]) == 0 {
-				return false, errors.New("Empty version part")
-			}
-			cP, err = strconv.ParseUint(candidateParts[i], 10, 16)
-			if err != nil {
-				return false, errors.New("Invalid version integer part")
-			}
+// findCandidate returns the newest MSI of channel, if it is newer than us, so
+// that switching to a more stable channel never downgrades. MSIs whose names
+// do not hold a valid version are skipped, so that one bad name does not keep
+// the others from being found. Of versions that differ only in their build
+// metadata, the one whose name sorts last wins, whatever the order of the list.
+func findCandidate(candidates fileList, source *Source, channel Channel) (*UpdateFound, error) {
+	prefix := fmt.Sprintf(source.MsiPrefix, version.Arch())
+	suffix := source.MsiSuffix
+	var newest *UpdateFound
+	for name, hash := range candidates {
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
 		}
-		if i < len(ourParts) {
-			if len(ourParts[i]) == 0 {
-				return false, errors.New("Empty version part")
-			}
-			oP, err = strconv.ParseUint(ourParts[i], 10, 16)
-			if err != nil {
-				return false, errors.New("Invalid version integer part")
-			}
+		number := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+		if len(number) > 128 {
+			log.Printf("Update checker: skipping %#q, whose version is too long", name)
+			continue
 		}
-		if cP == oP {
+		v, err := version.Parse(number)
+		if err != nil {
+			log.Printf("Update checker: skipping %#q, whose version is invalid: %v", name, err)
 			continue
 		}
-		return cP > oP, nil
-	}
-	return false, nil
-}
-
-func findCandidate(candidates fileList) (*UpdateFound, error) {
-	prefix := fmt.Sprintf(msiArchPrefix, version.Arch())
-	suffix := msiSuffix
-	for name, hash := range candidates {
-		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
-			version := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
-			if len(version) > 128 {
-				return nil, errors.New("Version length is too long")
-			}
-			newer, err := versionNewerThanUs(version)
-			if err != nil {
-				return nil, err
-			}
-			if newer {
-				return &UpdateFound{name, hash}, nil
+		if v.Compare(version.Current()) <= 0 {
+			continue
+		}
+		if newest != nil {
+			if c := v.Compare(newest.version); c < 0 || (c == 0 && name < newest.name) {
+				continue
 			}
 		}
+		newest = &UpdateFound{name: name, hash: hash, channel: channel, version: v}
 	}
-	return nil, nil
+	return newest, nil
 }
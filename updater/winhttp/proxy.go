@@ -0,0 +1,158 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package winhttp
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Proxy chooses how a session reaches servers, which, when it is the zero
+// value, is as the system proxy settings say.
+type Proxy struct {
+	Direct     bool   // Connect directly, ignoring the system settings.
+	Server     string // Connect through this proxy, as host:port.
+	PacURL     string // Find the proxy for each URL with the auto-config script at this URL.
+	AutoDetect bool   // Find the proxy for each URL with a script discovered by WPAD.
+	Username   string // Credentials for the proxy, or empty to use those of the account running the session.
+	Password   string
+}
+
+func (proxy Proxy) String() string {
+	var s string
+	switch {
+	case proxy.Direct:
+		return "no proxy"
+	case len(proxy.Server) > 0:
+		s = "proxy " + proxy.Server
+	case len(proxy.PacURL) > 0:
+		s = "proxy auto-config script " + proxy.PacURL
+	case proxy.AutoDetect:
+		s = "proxy auto-detection"
+	default:
+		s = "system proxy settings"
+	}
+	if len(proxy.Username) > 0 {
+		s += " as " + proxy.Username
+	}
+	return s
+}
+
+func (proxy Proxy) usesScript() bool {
+	return !proxy.Direct && len(proxy.Server) == 0 && (len(proxy.PacURL) > 0 || proxy.AutoDetect)
+}
+
+func (info *_WINHTTP_PROXY_INFO) free() {
+	if info.proxy != nil {
+		globalFree(uintptr(unsafe.Pointer(info.proxy)))
+		info.proxy = nil
+	}
+	if info.proxyBypass != nil {
+		globalFree(uintptr(unsafe.Pointer(info.proxyBypass)))
+		info.proxyBypass = nil
+	}
+}
+
+// resolveProxy runs the proxy auto-config script for url, and returns the
+// proxy it chooses, which must be freed. Failing to discover a script makes
+// auto-detection connect directly, as browsers do.
+func (session *Session) resolveProxy(url string) (info _WINHTTP_PROXY_INFO, err error) {
+	url16, err := windows.UTF16PtrFromString(url)
+	if err != nil {
+		return
+	}
+	options := _WINHTTP_AUTOPROXY_OPTIONS{autoLogonIfChallenged: 1}
+	if len(session.proxy.PacURL) > 0 {
+		options.flags = _WINHTTP_AUTOPROXY_CONFIG_URL
+		options.autoConfigUrl, err = windows.UTF16PtrFromString(session.proxy.PacURL)
+		if err != nil {
+			return
+		}
+	} else {
+		options.flags = _WINHTTP_AUTOPROXY_AUTO_DETECT
+		options.autoDetectFlags = _WINHTTP_AUTO_DETECT_TYPE_DHCP | _WINHTTP_AUTO_DETECT_TYPE_DNS_A
+	}
+	err = winHttpGetProxyForUrl(session.handle, url16, &options, &info)
+	if errors.Is(err, windows.Errno(_ERROR_WINHTTP_AUTODETECTION_FAILED)) && len(session.proxy.PacURL) == 0 {
+		return _WINHTTP_PROXY_INFO{accessType: _WINHTTP_ACCESS_TYPE_NO_PROXY}, nil
+	}
+	return
+}
+
+// ProxyFor describes the proxy that the session uses to reach url, running
+// the proxy auto-config script if there is one.
+func (session *Session) ProxyFor(url string) (description string, err error) {
+	defer convertError(&err)
+	if !session.proxy.usesScript() {
+		return session.proxy.String(), nil
+	}
+	info, err := session.resolveProxy(url)
+	if err != nil {
+		return
+	}
+	defer info.free()
+	if info.accessType == _WINHTTP_ACCESS_TYPE_NO_PROXY || info.proxy == nil {
+		return fmt.Sprintf("no proxy, according to %s", session.proxy), nil
+	}
+	return fmt.Sprintf("proxy %s, according to %s", windows.UTF16PtrToString(info.proxy), session.proxy), nil
+}
+
+// applyProxy sets the proxy of a request for url, if it is chosen by a proxy
+// auto-config script, rather than by the session.
+func (session *Session) applyProxy(request _HINTERNET, url string) error {
+	if !session.proxy.usesScript() {
+		return nil
+	}
+	info, err := session.resolveProxy(url)
+	if err != nil {
+		return err
+	}
+	defer info.free()
+	return winHttpSetOption(request, _WINHTTP_OPTION_PROXY, unsafe.Pointer(&info), uint32(unsafe.Sizeof(info)))
+}
+
+// authenticateToProxy answers the proxy's challenge to a request with the
+// strongest scheme it offers, using the configured credentials, or otherwise
+// those of the account running the session, which only Negotiate and NTLM
+// can use.
+func (session *Session) authenticateToProxy(request _HINTERNET) error {
+	var supported, first, target uint32
+	err := winHttpQueryAuthSchemes(request, &supported, &first, &target)
+	if err != nil {
+		return err
+	}
+	var username16, password16 *uint16
+	schemes := []uint32{_WINHTTP_AUTH_SCHEME_NEGOTIATE, _WINHTTP_AUTH_SCHEME_NTLM}
+	if len(session.proxy.Username) > 0 {
+		username16, err = windows.UTF16PtrFromString(session.proxy.Username)
+		if err != nil {
+			return err
+		}
+		password16, err = windows.UTF16PtrFromString(session.proxy.Password)
+		if err != nil {
+			return err
+		}
+		schemes = append(schemes, _WINHTTP_AUTH_SCHEME_DIGEST, _WINHTTP_AUTH_SCHEME_BASIC)
+	} else {
+		var policy uint32 = _WINHTTP_AUTOLOGON_SECURITY_LEVEL_LOW
+		err = winHttpSetOption(request, _WINHTTP_OPTION_AUTOLOGON_POLICY, unsafe.Pointer(&policy), uint32(unsafe.Sizeof(policy)))
+		if err != nil {
+			return err
+		}
+	}
+	for _, scheme := range schemes {
+		if supported&scheme != 0 {
+			return winHttpSetCredentials(request, _WINHTTP_AUTH_TARGET_PROXY, scheme, username16, password16, 0)
+		}
+	}
+	if len(session.proxy.Username) > 0 {
+		return errors.New("Proxy requires an unsupported authentication scheme")
+	}
+	return errors.New("Proxy requires a username and password")
+}
@@ -19,6 +19,25 @@ const (
 	_WINHTTP_ACCESS_TYPE_NAMED_PROXY     = 3
 	_WINHTTP_ACCESS_TYPE_AUTOMATIC_PROXY = 4
 
+	_WINHTTP_AUTOPROXY_AUTO_DETECT = 0x00000001
+	_WINHTTP_AUTOPROXY_CONFIG_URL  = 0x00000002
+
+	_WINHTTP_AUTO_DETECT_TYPE_DHCP  = 0x00000001
+	_WINHTTP_AUTO_DETECT_TYPE_DNS_A = 0x00000002
+
+	_WINHTTP_AUTH_TARGET_SERVER = 0x00000000
+	_WINHTTP_AUTH_TARGET_PROXY  = 0x00000001
+
+	_WINHTTP_AUTH_SCHEME_BASIC     = 0x00000001
+	_WINHTTP_AUTH_SCHEME_NTLM      = 0x00000002
+	_WINHTTP_AUTH_SCHEME_PASSPORT  = 0x00000004
+	_WINHTTP_AUTH_SCHEME_DIGEST    = 0x00000008
+	_WINHTTP_AUTH_SCHEME_NEGOTIATE = 0x00000010
+
+	_WINHTTP_AUTOLOGON_SECURITY_LEVEL_MEDIUM = 0
+	_WINHTTP_AUTOLOGON_SECURITY_LEVEL_LOW    = 1
+	_WINHTTP_AUTOLOGON_SECURITY_LEVEL_HIGH   = 2
+
 	_WINHTTP_FLAG_ASYNC = 0x10000000
 
 	_WINHTTP_INVALID_STATUS_CALLBACK = ^uintptr(0)
@@ -323,6 +342,21 @@ const (
 	_WINHTTP_ERROR_LAST                                  = _WINHTTP_ERROR_BASE + 190
 )
 
+type _WINHTTP_AUTOPROXY_OPTIONS struct {
+	flags                 uint32
+	autoDetectFlags       uint32
+	autoConfigUrl         *uint16
+	reserved1             uintptr
+	reserved2             uint32
+	autoLogonIfChallenged int32
+}
+
+type _WINHTTP_PROXY_INFO struct {
+	accessType  uint32
+	proxy       *uint16
+	proxyBypass *uint16
+}
+
 type _URL_COMPONENTS struct {
 	structSize      uint32
 	scheme          *uint16
@@ -353,3 +387,7 @@ type _URL_COMPONENTS struct {
 //sys	winHttpReadData(requestHandle _HINTERNET, buffer *byte, bufferSize uint32, bytesRead *uint32) (err error) = winhttp.WinHttpReadData
 //sys	winHttpCrackUrl(url *uint16, urlSize uint32, flags uint32, components *_URL_COMPONENTS) (err error) = winhttp.WinHttpCrackUrl
 //sys	winHttpSetOption(sessionOrRequestHandle _HINTERNET, option uint32, buffer unsafe.Pointer, bufferLen uint32) (err error) = winhttp.WinHttpSetOption
+//sys	winHttpGetProxyForUrl(sessionHandle _HINTERNET, url *uint16, autoProxyOptions *_WINHTTP_AUTOPROXY_OPTIONS, proxyInfo *_WINHTTP_PROXY_INFO) (err error) = winhttp.WinHttpGetProxyForUrl
+//sys	winHttpQueryAuthSchemes(requestHandle _HINTERNET, supportedSchemes *uint32, firstScheme *uint32, authTarget *uint32) (err error) = winhttp.WinHttpQueryAuthSchemes
+//sys	winHttpSetCredentials(requestHandle _HINTERNET, authTargets uint32, authScheme uint32, username *uint16, password *uint16, authParams uintptr) (err error) = winhttp.WinHttpSetCredentials
+//sys	globalFree(mem uintptr) (handle uintptr, err error) [failretval!=0] = kernel32.GlobalFree
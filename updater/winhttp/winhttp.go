@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,11 +21,14 @@ import (
 
 type Session struct {
 	handle _HINTERNET
+	proxy  Proxy
 }
 
 type Connection struct {
 	handle  _HINTERNET
 	session *Session
+	server  string
+	port    uint16
 	https   bool
 }
 
@@ -56,7 +60,12 @@ func isWin8DotZeroOrBelow() bool {
 }
 
 func NewSession(userAgent string) (session *Session, err error) {
-	session = new(Session)
+	return NewSessionWithProxy(userAgent, Proxy{})
+}
+
+// NewSessionWithProxy is like NewSession, but reaches servers as proxy says.
+func NewSessionWithProxy(userAgent string, proxy Proxy) (session *Session, err error) {
+	session = &Session{proxy: proxy}
 	defer convertError(&err)
 	defer func() {
 		if err != nil {
@@ -69,10 +78,23 @@ func NewSession(userAgent string) (session *Session, err error) {
 		return
 	}
 	var proxyFlag uint32 = _WINHTTP_ACCESS_TYPE_AUTOMATIC_PROXY
-	if isWin7() {
+	var proxy16, proxyBypass16 *uint16
+	if proxy.Direct || proxy.usesScript() {
+		proxyFlag = _WINHTTP_ACCESS_TYPE_NO_PROXY
+	} else if len(proxy.Server) > 0 {
+		proxyFlag = _WINHTTP_ACCESS_TYPE_NAMED_PROXY
+		proxy16, err = windows.UTF16PtrFromString(proxy.Server)
+		if err != nil {
+			return
+		}
+		proxyBypass16, err = windows.UTF16PtrFromString("<local>")
+		if err != nil {
+			return
+		}
+	} else if isWin7() {
 		proxyFlag = _WINHTTP_ACCESS_TYPE_DEFAULT_PROXY
 	}
-	session.handle, err = winHttpOpen(userAgent16, proxyFlag, nil, nil, 0)
+	session.handle, err = winHttpOpen(userAgent16, proxyFlag, proxy16, proxyBypass16, 0)
 	if err != nil {
 		return
 	}
@@ -119,7 +141,7 @@ func (session *Session) Connect(server string, port uint16, https bool) (connect
 	if err != nil {
 		return
 	}
-	connection.https = https
+	connection.server, connection.port, connection.https = server, port, https
 
 	runtime.SetFinalizer(connection, func(connection *Connection) {
 		connection.Close()
@@ -137,6 +159,19 @@ func (connection *Connection) Close() (err error) {
 }
 
 func (connection *Connection) Get(path string, refresh bool) (response *Response, err error) {
+	return connection.get(path, refresh, "")
+}
+
+// GetFrom requests the bytes of path from offset onward, which the server
+// may ignore, responding with all of them and status 200 rather than 206.
+func (connection *Connection) GetFrom(path string, offset uint64) (response *Response, err error) {
+	if offset == 0 {
+		return connection.get(path, false, "")
+	}
+	return connection.get(path, false, fmt.Sprintf("Range: bytes=%d-\r\n", offset))
+}
+
+func (connection *Connection) get(path string, refresh bool, headers string) (response *Response, err error) {
 	response = &Response{connection: connection}
 	defer convertError(&err)
 	defer func() {
@@ -164,11 +199,41 @@ func (connection *Connection) Get(path string, refresh bool) (response *Response
 	if err != nil {
 		return
 	}
-	err = winHttpSendRequest(response.handle, nil, 0, nil, 0, 0, 0)
+	err = connection.session.applyProxy(response.handle, connection.url(path))
 	if err != nil {
 		return
 	}
-	err = winHttpReceiveResponse(response.handle, 0)
+	var headers16 *uint16
+	var headersLen uint32
+	if len(headers) > 0 {
+		headers16, err = windows.UTF16PtrFromString(headers)
+		if err != nil {
+			return
+		}
+		headersLen = ^uint32(0) // Null-terminated
+	}
+	for authenticated := false; ; authenticated = true {
+		err = winHttpSendRequest(response.handle, headers16, headersLen, nil, 0, 0, 0)
+		if err != nil {
+			return
+		}
+		err = winHttpReceiveResponse(response.handle, 0)
+		if err != nil {
+			return
+		}
+		if authenticated {
+			break
+		}
+		var status uint32
+		status, err = response.StatusCode()
+		if err != nil || status != 407 {
+			break
+		}
+		err = connection.session.authenticateToProxy(response.handle)
+		if err != nil {
+			return
+		}
+	}
 	if err != nil {
 		return
 	}
@@ -179,6 +244,15 @@ func (connection *Connection) Get(path string, refresh bool) (response *Response
 	return
 }
 
+// url returns the URL of path on the connection's server, for choosing a proxy.
+func (connection *Connection) url(path string) string {
+	scheme := "http"
+	if connection.https {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(connection.server, strconv.Itoa(int(connection.port))) + path
+}
+
 func (response *Response) Length() (length uint64, err error) {
 	defer convertError(&err)
 	numBuf := make([]uint16, 22)
@@ -194,6 +268,26 @@ func (response *Response) Length() (length uint64, err error) {
 	return
 }
 
+// ContentRange returns the Content-Range header of a partial response.
+func (response *Response) ContentRange() (contentRange string, err error) {
+	defer convertError(&err)
+	buf := make([]uint16, 64)
+	bufLen := uint32(len(buf) * 2)
+	err = winHttpQueryHeaders(response.handle, _WINHTTP_QUERY_CONTENT_RANGE, nil, unsafe.Pointer(&buf[0]), &bufLen, nil)
+	if err != nil {
+		return
+	}
+	contentRange = windows.UTF16ToString(buf[:bufLen/2])
+	return
+}
+
+func (response *Response) StatusCode() (code uint32, err error) {
+	defer convertError(&err)
+	codeLen := uint32(unsafe.Sizeof(code))
+	err = winHttpQueryHeaders(response.handle, _WINHTTP_QUERY_STATUS_CODE|_WINHTTP_QUERY_FLAG_NUMBER, nil, unsafe.Pointer(&code), &codeLen, nil)
+	return
+}
+
 func (response *Response) Read(p []byte) (n int, err error) {
 	defer convertError(&err)
 	if len(p) == 0 {
@@ -202,7 +296,7 @@ func (response *Response) Read(p []byte) (n int, err error) {
 	var bytesRead uint32
 	err = winHttpReadData(response.handle, &p[0], uint32(len(p)), &bytesRead)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	if bytesRead == 0 || int(bytesRead) < 0 {
 		return 0, io.EOF
@@ -8,7 +8,10 @@ package winhttp
 import (
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -69,3 +72,61 @@ func TestResponse(t *testing.T) {
 
 	runtime.GC() // Try to force the finalizers to be called
 }
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") == "" {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		username, password, ok := (&http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}).BasicAuth()
+		if !ok || username != "user" || password != "pass" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, "proxied %s%s", r.URL.Host, r.URL.Path)
+	}))
+	defer proxy.Close()
+	server := strings.TrimPrefix(proxy.URL, "http://")
+
+	for _, test := range []struct {
+		username string
+		valid    bool
+	}{{"user", true}, {"", false}} {
+		session, err := NewSessionWithProxy("WinHTTP Test Suite/1.0", Proxy{Server: server, Username: test.username, Password: "pass"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if description, err := session.ProxyFor("http://updates.example/list"); err != nil || !strings.Contains(description, server) {
+			t.Errorf("Proxy is described as %#q: %v", description, err)
+		}
+		connection, err := session.Connect("updates.example", 80, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := connection.Get("/list", true)
+		if !test.valid {
+			if err == nil {
+				t.Error("Request without credentials for a Basic proxy succeeded")
+				r.Close()
+			}
+			connection.Close()
+			session.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bytes) != "proxied updates.example/list" {
+			t.Errorf("Proxy returned %#q", bytes)
+		}
+		r.Close()
+		connection.Close()
+		session.Close()
+	}
+}
@@ -38,22 +38,36 @@ func errnoErr(e syscall.Errno) error {
 }
 
 var (
-	modwinhttp = windows.NewLazySystemDLL("winhttp.dll")
+	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
+	modwinhttp  = windows.NewLazySystemDLL("winhttp.dll")
 
+	procGlobalFree                = modkernel32.NewProc("GlobalFree")
 	procWinHttpCloseHandle        = modwinhttp.NewProc("WinHttpCloseHandle")
 	procWinHttpConnect            = modwinhttp.NewProc("WinHttpConnect")
 	procWinHttpCrackUrl           = modwinhttp.NewProc("WinHttpCrackUrl")
+	procWinHttpGetProxyForUrl     = modwinhttp.NewProc("WinHttpGetProxyForUrl")
 	procWinHttpOpen               = modwinhttp.NewProc("WinHttpOpen")
 	procWinHttpOpenRequest        = modwinhttp.NewProc("WinHttpOpenRequest")
+	procWinHttpQueryAuthSchemes   = modwinhttp.NewProc("WinHttpQueryAuthSchemes")
 	procWinHttpQueryDataAvailable = modwinhttp.NewProc("WinHttpQueryDataAvailable")
 	procWinHttpQueryHeaders       = modwinhttp.NewProc("WinHttpQueryHeaders")
 	procWinHttpReadData           = modwinhttp.NewProc("WinHttpReadData")
 	procWinHttpReceiveResponse    = modwinhttp.NewProc("WinHttpReceiveResponse")
 	procWinHttpSendRequest        = modwinhttp.NewProc("WinHttpSendRequest")
+	procWinHttpSetCredentials     = modwinhttp.NewProc("WinHttpSetCredentials")
 	procWinHttpSetOption          = modwinhttp.NewProc("WinHttpSetOption")
 	procWinHttpSetStatusCallback  = modwinhttp.NewProc("WinHttpSetStatusCallback")
 )
 
+func globalFree(mem uintptr) (handle uintptr, err error) {
+	r0, _, e1 := syscall.Syscall(procGlobalFree.Addr(), 1, uintptr(mem), 0, 0)
+	handle = uintptr(r0)
+	if handle != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
 func winHttpCloseHandle(handle _HINTERNET) (err error) {
 	r1, _, e1 := syscall.Syscall(procWinHttpCloseHandle.Addr(), 1, uintptr(handle), 0, 0)
 	if r1 == 0 {
@@ -79,6 +93,14 @@ func winHttpCrackUrl(url *uint16, urlSize uint32, flags uint32, components *_URL
 	return
 }
 
+func winHttpGetProxyForUrl(sessionHandle _HINTERNET, url *uint16, autoProxyOptions *_WINHTTP_AUTOPROXY_OPTIONS, proxyInfo *_WINHTTP_PROXY_INFO) (err error) {
+	r1, _, e1 := syscall.Syscall6(procWinHttpGetProxyForUrl.Addr(), 4, uintptr(sessionHandle), uintptr(unsafe.Pointer(url)), uintptr(unsafe.Pointer(autoProxyOptions)), uintptr(unsafe.Pointer(proxyInfo)), 0, 0)
+	if r1 == 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
 func winHttpOpen(userAgent *uint16, accessType uint32, proxy *uint16, proxyBypass *uint16, flags uint32) (sessionHandle _HINTERNET, err error) {
 	r0, _, e1 := syscall.Syscall6(procWinHttpOpen.Addr(), 5, uintptr(unsafe.Pointer(userAgent)), uintptr(accessType), uintptr(unsafe.Pointer(proxy)), uintptr(unsafe.Pointer(proxyBypass)), uintptr(flags), 0)
 	sessionHandle = _HINTERNET(r0)
@@ -97,6 +119,14 @@ func winHttpOpenRequest(connectHandle _HINTERNET, verb *uint16, objectName *uint
 	return
 }
 
+func winHttpQueryAuthSchemes(requestHandle _HINTERNET, supportedSchemes *uint32, firstScheme *uint32, authTarget *uint32) (err error) {
+	r1, _, e1 := syscall.Syscall6(procWinHttpQueryAuthSchemes.Addr(), 4, uintptr(requestHandle), uintptr(unsafe.Pointer(supportedSchemes)), uintptr(unsafe.Pointer(firstScheme)), uintptr(unsafe.Pointer(authTarget)), 0, 0)
+	if r1 == 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
 func winHttpQueryDataAvailable(requestHandle _HINTERNET, bytesAvailable *uint32) (err error) {
 	r1, _, e1 := syscall.Syscall(procWinHttpQueryDataAvailable.Addr(), 2, uintptr(requestHandle), uintptr(unsafe.Pointer(bytesAvailable)), 0)
 	if r1 == 0 {
@@ -137,6 +167,14 @@ func winHttpSendRequest(requestHandle _HINTERNET, headers *uint16, headersLength
 	return
 }
 
+func winHttpSetCredentials(requestHandle _HINTERNET, authTargets uint32, authScheme uint32, username *uint16, password *uint16, authParams uintptr) (err error) {
+	r1, _, e1 := syscall.Syscall6(procWinHttpSetCredentials.Addr(), 6, uintptr(requestHandle), uintptr(authTargets), uintptr(authScheme), uintptr(unsafe.Pointer(username)), uintptr(unsafe.Pointer(password)), uintptr(authParams))
+	if r1 == 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
 func winHttpSetOption(sessionOrRequestHandle _HINTERNET, option uint32, buffer unsafe.Pointer, bufferLen uint32) (err error) {
 	r1, _, e1 := syscall.Syscall6(procWinHttpSetOption.Addr(), 4, uintptr(sessionOrRequestHandle), uintptr(option), uintptr(buffer), uintptr(bufferLen), 0, 0)
 	if r1 == 0 {
@@ -0,0 +1,61 @@
+// Code generated by 'go generate'; DO NOT EDIT.
+
+package updater
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var _ unsafe.Pointer
+
+// Do the interface allocations only once for common
+// Errno values.
+const (
+	errnoERROR_IO_PENDING = 997
+)
+
+var (
+	errERROR_IO_PENDING error = syscall.Errno(errnoERROR_IO_PENDING)
+	errERROR_EINVAL     error = syscall.EINVAL
+)
+
+// errnoErr returns common boxed Errno values, to prevent
+// allocations at runtime.
+func errnoErr(e syscall.Errno) error {
+	switch e {
+	case 0:
+		return errERROR_EINVAL
+	case errnoERROR_IO_PENDING:
+		return errERROR_IO_PENDING
+	}
+	// TODO: add more here, after collecting data on the common
+	// error values see on Windows. (perhaps when running
+	// all.bat?)
+	return e
+}
+
+var (
+	modmsi = windows.NewLazySystemDLL("msi.dll")
+
+	procMsiEnumRelatedProductsW = modmsi.NewProc("MsiEnumRelatedProductsW")
+	procMsiGetProductInfoW      = modmsi.NewProc("MsiGetProductInfoW")
+)
+
+func msiEnumRelatedProducts(upgradeCode *uint16, reserved uint32, index uint32, productCode *uint16) (ret error) {
+	r0, _, _ := syscall.Syscall6(procMsiEnumRelatedProductsW.Addr(), 4, uintptr(unsafe.Pointer(upgradeCode)), uintptr(reserved), uintptr(index), uintptr(unsafe.Pointer(productCode)), 0, 0)
+	if r0 != 0 {
+		ret = syscall.Errno(r0)
+	}
+	return
+}
+
+func msiGetProductInfo(product *uint16, property *uint16, value *uint16, valueLen *uint32) (ret error) {
+	r0, _, _ := syscall.Syscall6(procMsiGetProductInfoW.Addr(), 4, uintptr(unsafe.Pointer(product)), uintptr(unsafe.Pointer(property)), uintptr(unsafe.Pointer(value)), uintptr(unsafe.Pointer(valueLen)), 0, 0)
+	if r0 != 0 {
+		ret = syscall.Errno(r0)
+	}
+	return
+}
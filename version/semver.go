@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package version
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Version is a version number like those of semantic versioning, such as
+// 1.0.5-rc.1+build.7, though with any number of release numbers.
+type Version struct {
+	Release    []uint64 // Release numbers, of which missing trailing ones are zero.
+	PreRelease []string // Identifiers after the hyphen, if a pre-release.
+	Build      string   // Metadata after the plus sign, which does not affect ordering.
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// Parse parses a version of the form RELEASE[-PRERELEASE][+BUILD], where
+// RELEASE is dot-separated integers, and PRERELEASE and BUILD dot-separated
+// identifiers of ASCII letters, digits, and hyphens.
+func Parse(s string) (Version, error) {
+	var v Version
+	s, build, hasBuild := strings.Cut(s, "+")
+	if hasBuild {
+		for _, identifier := range strings.Split(build, ".") {
+			if !isIdentifier(identifier) {
+				return Version{}, errors.New("Invalid version build metadata")
+			}
+		}
+		v.Build = build
+	}
+	release, preRelease, hasPreRelease := strings.Cut(s, "-")
+	if hasPreRelease {
+		v.PreRelease = strings.Split(preRelease, ".")
+		for _, identifier := range v.PreRelease {
+			if !isIdentifier(identifier) || (isNumeric(identifier) && len(identifier) > 1 && identifier[0] == '0') {
+				return Version{}, errors.New("Invalid version pre-release identifier")
+			}
+		}
+	}
+	for _, part := range strings.Split(release, ".") {
+		if len(part) == 0 {
+			return Version{}, errors.New("Empty version part")
+		}
+		if !isNumeric(part) {
+			return Version{}, errors.New("Invalid version integer part")
+		}
+		number, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, errors.New("Invalid version integer part")
+		}
+		v.Release = append(v.Release, number)
+	}
+	return v, nil
+}
+
+// Current returns the version of this build.
+func Current() Version {
+	v, err := Parse(Number)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// IsPreRelease returns whether v is a pre-release, which orders before the
+// release of the same number.
+func (v Version) IsPreRelease() bool {
+	return len(v.PreRelease) > 0
+}
+
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			return compareInts(len(a), len(b))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareInts[T int | uint64](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Compare returns -1, 0, or 1, as v orders before, the same as, or after w,
+// following the precedence rules of semantic versioning.
+func (v Version) Compare(w Version) int {
+	for i := 0; i < max(len(v.Release), len(w.Release)); i++ {
+		var a, b uint64
+		if i < len(v.Release) {
+			a = v.Release[i]
+		}
+		if i < len(w.Release) {
+			b = w.Release[i]
+		}
+		if c := compareInts(a, b); c != 0 {
+			return c
+		}
+	}
+	if !v.IsPreRelease() || !w.IsPreRelease() {
+		return -compareInts(len(v.PreRelease), len(w.PreRelease))
+	}
+	for i := 0; i < min(len(v.PreRelease), len(w.PreRelease)); i++ {
+		if c := compareIdentifiers(v.PreRelease[i], w.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.PreRelease), len(w.PreRelease))
+}
+
+func (v Version) String() string {
+	var b strings.Builder
+	for i, number := range v.Release {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.FormatUint(number, 10))
+	}
+	if v.IsPreRelease() {
+		b.WriteByte('-')
+		b.WriteString(strings.Join(v.PreRelease, "."))
+	}
+	if len(v.Build) > 0 {
+		b.WriteByte('+')
+		b.WriteString(v.Build)
+	}
+	return b.String()
+}
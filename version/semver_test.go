@@ -0,0 +1,101 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019-2022 WireGuard LLC. All Rights Reserved.
+ */
+
+package version
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"1.0.2", true},
+		{"0.5.3.1", true},
+		{"1", true},
+		{"1.0.5-rc1", true},
+		{"1.0.5-rc.1", true},
+		{"1.0.5-0.3.7", true},
+		{"1.0.5-x-y-z.--", true},
+		{"1.0.5+build.7", true},
+		{"1.0.5-beta+exp.sha.5114f85", true},
+		{"1.0.5+001", true},
+		{"", false},
+		{"1..2", false},
+		{"1.0.", false},
+		{"v1.0.2", false},
+		{"1.0.2a", false},
+		{"-1.0", false},
+		{"1.0.5-", false},
+		{"1.0.5-rc..1", false},
+		{"1.0.5-01", false},
+		{"1.0.5-rc_1", false},
+		{"1.0.5+", false},
+		{"1.0.5+build..7", false},
+		{"99999999999999999999999", false},
+	}
+	for _, test := range tests {
+		v, err := Parse(test.input)
+		if test.valid && err != nil {
+			t.Errorf("%#q was rejected: %v", test.input, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%#q was accepted as %v", test.input, v)
+		} else if test.valid && v.String() != test.input {
+			t.Errorf("%#q formats as %#q", test.input, v.String())
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.2", "1.0.2", 0},
+		{"1.0.2", "1.0.10", -1},
+		{"1.1", "1.0.9", 1},
+		{"1.0", "1.0.0.0", 0},
+		{"1.0.1", "1.0", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.0.5-rc1", "1.0.5", -1},
+		{"1.0.5-rc1", "1.0.4", 1},
+		{"1.0.5", "1.0.5+build.7", 0},
+		{"1.0.5-rc.1+a", "1.0.5-rc.1+b", 0},
+		// The example ordering of the semantic versioning specification.
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc10", "1.0.0-rc9", -1},
+		{"1.0.0-rc.10", "1.0.0-rc.9", 1},
+	}
+	for _, test := range tests {
+		a, err := Parse(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Parse(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Compare(b); got != test.want {
+			t.Errorf("Comparing %#q to %#q gave %d, but expected %d", test.a, test.b, got, test.want)
+		}
+		if got := b.Compare(a); got != -test.want {
+			t.Errorf("Comparing %#q to %#q gave %d, but expected %d", test.b, test.a, got, -test.want)
+		}
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	if Current().String() != Number {
+		t.Errorf("Version %#q does not parse", Number)
+	}
+}